@@ -0,0 +1,423 @@
+/*
+Copyright (c) 2019 Open-E, Inc.
+All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License"); you may
+not use this file except in compliance with the License. You may obtain
+a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+License for the specific language governing permissions and limitations
+under the License.
+*/
+
+// jdssctl inspects and repairs objects created by JovianDSS CSI plugin
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/joviandss"
+)
+
+const (
+	defaultConfigPath = "/config/config.yaml"
+
+	outputTable = "table"
+	outputJSON  = "json"
+
+	flattenPollInterval = 5 * time.Second
+)
+
+const usage = `Usage: jdssctl [flags] <command> [args]
+
+Commands:
+  volumes            list volumes with their CSI names, including concealed ones
+  snapshots          list snapshots, including concealed ones
+  chain <volume id>  show snapshots the volume is cloned from, nearest first
+  flatten [-wait] <volume id>
+                     replace clone with its full copy, so concealed parents can be freed
+  rollback [-force] <volume id> <snapshot id>
+                     revert unpublished volume to its snapshot, -force allows
+                     deletion of newer snapshots
+  replication        show replication state and lag of replicated volumes
+  promote <volume id>
+                     make replica of volume on secondary storage writable
+  export <snapshot id> <target uri> [name]
+                     store snapshot data in file://, tar:// or s3:// target
+  import <name> <source uri> <stream name>
+                     create volume with given CSI name out of exported stream
+  modify <volume id> <parameter>=<value>...
+                     change IO limits: readIOPS, writeIOPS, readBandwidth, writeBandwidth
+  targets            list iSCSI targets with attached volumes and active sessions
+  gc [-apply -yes]   list concealed objects that are no longer needed,
+                     delete them if -apply is set, -yes confirms deletion,
+                     controller should be stopped first
+
+Commands flatten, rollback, promote, export, import and modify are sent
+to running controller given by -csi-address, or require -stopped.
+
+Flags:
+`
+
+var (
+	configPath *string
+	csiAddress *string
+	stopped    *bool
+	output     *string
+	logLevel   *string
+)
+
+func main() {
+	configPath = flag.String("config", defaultConfigPath, "controller config file")
+	csiAddress = flag.String("csi-address", "", "unix socket of running controller, volumes are modified through it")
+	stopped = flag.Bool("stopped", false, "controller is stopped, modify volumes directly")
+	output = flag.String("o", outputTable, "output format: table or json")
+	logLevel = flag.String("loglevel", "warn", "log level")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != outputTable && *output != outputJSON {
+		fail(fmt.Errorf("Unknown output format %s", *output))
+	}
+
+	in, err := getInspector()
+	if err != nil {
+		fail(err)
+	}
+
+	args := flag.Args()
+	switch args[0] {
+	case "volumes":
+		err = listVolumes(in)
+	case "snapshots":
+		err = listSnapshots(in)
+	case "chain":
+		if len(args) != 2 {
+			fail(fmt.Errorf("chain requires volume id"))
+		}
+		err = showChain(in, args[1])
+	case "flatten":
+		err = flatten(in, args[1:])
+	case "rollback":
+		err = rollback(in, args[1:])
+	case "replication":
+		err = listReplication(in)
+	case "promote":
+		if len(args) != 2 {
+			fail(fmt.Errorf("promote requires volume id"))
+		}
+		err = promote(in, args[1])
+	case "export":
+		if len(args) != 3 && len(args) != 4 {
+			fail(fmt.Errorf("export requires snapshot id and target uri"))
+		}
+		name := ""
+		if len(args) == 4 {
+			name = args[3]
+		}
+		err = export(in, args[1], args[2], name)
+	case "import":
+		if len(args) != 4 {
+			fail(fmt.Errorf("import requires volume name, source uri and stream name"))
+		}
+		err = importVolume(in, args[1], args[2], args[3])
+	case "modify":
+		if len(args) < 3 {
+			fail(fmt.Errorf("modify requires volume id and parameters"))
+		}
+		err = modify(in, args[1], args[2:])
+	case "targets":
+		err = listTargets(in)
+	case "gc":
+		err = runGC(in, args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	os.Exit(1)
+}
+
+func getInspector() (*joviandss.Inspector, error) {
+	cfg, err := joviandss.GetConfing(*configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	log := logrus.New()
+	log.Out = os.Stderr
+	lvl, err := logrus.ParseLevel(*logLevel)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(lvl)
+
+	l := log.WithFields(logrus.Fields{
+		"obj": "jdssctl",
+	})
+	in, err := joviandss.NewInspector(&cfg.Controller, l)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(*csiAddress) > 0 {
+		err = in.Connect(*csiAddress)
+	} else if *stopped {
+		in.AssumeStopped()
+	}
+	return in, err
+}
+
+// print writes data as JSON or as table made of header and rows
+func print(data interface{}, header []string, rows [][]string) error {
+	if *output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	return w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func listVolumes(in *joviandss.Inspector) error {
+	vols, err := in.Volumes()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, v := range vols {
+		pvc := v.Owner
+		if len(v.Namespace) > 0 {
+			pvc = v.Namespace + "/" + v.Owner
+		}
+		rows = append(rows, []string{
+			v.ID, v.Name, pvc, fmt.Sprint(v.Size), yesNo(v.Concealed), v.Origin,
+		})
+	}
+	return print(vols, []string{"ID", "NAME", "PVC", "SIZE", "CONCEALED", "ORIGIN"}, rows)
+}
+
+func listSnapshots(in *joviandss.Inspector) error {
+	snaps, err := in.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, s := range snaps {
+		rows = append(rows, []string{
+			s.ID, s.Volume, s.Name, yesNo(s.Concealed), strings.Join(s.Clones, ","),
+		})
+	}
+	return print(snaps, []string{"ID", "VOLUME", "NAME", "CONCEALED", "CLONES"}, rows)
+}
+
+func showChain(in *joviandss.Inspector, vID string) error {
+	ancestors, err := in.Ancestry(vID)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, a := range ancestors {
+		rows = append(rows, []string{a.Volume, a.Snapshot, yesNo(a.Concealed)})
+	}
+	return print(ancestors, []string{"VOLUME", "SNAPSHOT", "CONCEALED"}, rows)
+}
+
+func flatten(in *joviandss.Inspector, args []string) error {
+	fs := flag.NewFlagSet("flatten", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "wait until volume is flattened")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("flatten requires volume id")
+	}
+
+	for {
+		rsp, err := in.Flatten(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if rsp.State == joviandss.FlattenDone || !*wait {
+			return print(rsp, []string{"STATE", "PROGRESS"},
+				[][]string{{rsp.State, fmt.Sprintf("%d%%", rsp.Progress)}})
+		}
+		time.Sleep(flattenPollInterval)
+	}
+}
+
+func rollback(in *joviandss.Inspector, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	force := fs.Bool("force", false, "delete snapshots newer than the given one")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("rollback requires volume id and snapshot id")
+	}
+
+	if err := in.Rollback(fs.Arg(0), fs.Arg(1), *force); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s rolled back to %s\n", fs.Arg(0), fs.Arg(1))
+	return nil
+}
+
+func listReplication(in *joviandss.Inspector) error {
+	states, err := in.Replication()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, s := range states {
+		lastSync := "-"
+		lag := "-"
+		if s.LastSync > 0 {
+			lastSync = time.Unix(s.LastSync, 0).Format(time.RFC3339)
+			lag = (time.Duration(s.Lag) * time.Second).String()
+		}
+		state := s.State
+		if s.State == joviandss.ReplicationRunning {
+			state = fmt.Sprintf("%s %d%%", s.State, s.Progress)
+		}
+		rows = append(rows, []string{s.VolumeID, s.Interval, state, lastSync, lag, s.Message})
+	}
+	return print(states, []string{"VOLUME", "INTERVAL", "STATE", "LAST SYNC", "LAG", "MESSAGE"}, rows)
+}
+
+func promote(in *joviandss.Inspector, vID string) error {
+	if err := in.Promote(vID); err != nil {
+		return err
+	}
+	fmt.Printf("Replica of volume %s promoted\n", vID)
+	return nil
+}
+
+func export(in *joviandss.Inspector, sID string, target string, name string) error {
+	rsp, err := in.Export(sID, target, name)
+	if err != nil {
+		return err
+	}
+	return print(rsp, []string{"TARGET", "NAME"}, [][]string{{rsp.Target, rsp.Name}})
+}
+
+func importVolume(in *joviandss.Inspector, name string, source string, stream string) error {
+	rsp, err := in.Import(name, source, stream)
+	if err != nil {
+		return err
+	}
+	return print(rsp, []string{"VOLUME", "SIZE"}, [][]string{{rsp.VolumeID, fmt.Sprint(rsp.CapacityBytes)}})
+}
+
+func modify(in *joviandss.Inspector, vID string, args []string) error {
+	params := make(map[string]string)
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Parameter %s should be <parameter>=<value>", a)
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	if err := in.Modify(vID, params); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s modified\n", vID)
+	return nil
+}
+
+func listTargets(in *joviandss.Inspector) error {
+	targets, err := in.Targets()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, t := range targets {
+		sessions := []string{}
+		for _, s := range t.Sessions {
+			sessions = append(sessions, fmt.Sprintf("%s(%s)", s.Initiator, s.Ip))
+		}
+		rows = append(rows, []string{
+			t.Name, yesNo(t.Active), strings.Join(t.Volumes, ","),
+			strings.Join(t.Users, ","), strings.Join(sessions, ","),
+		})
+	}
+	return print(targets, []string{"TARGET", "ACTIVE", "VOLUMES", "USERS", "SESSIONS"}, rows)
+}
+
+func runGC(in *joviandss.Inspector, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "delete found objects, only list them otherwise")
+	yes := fs.Bool("yes", false, "confirm deletion, controller is stopped")
+	fs.Parse(args)
+
+	if *apply && !*yes {
+		return fmt.Errorf("gc -apply deletes objects, stop controller and add -yes to confirm")
+	}
+
+	actions, err := in.CollectGarbage(*apply)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	failed := 0
+	for _, a := range actions {
+		state := "pending"
+		if *apply {
+			state = "deleted"
+			if !a.Done {
+				state = "failed: " + a.Error
+				failed++
+			}
+		}
+		rows = append(rows, []string{a.Volume, a.Snapshot, a.Reason, state})
+	}
+	if err = print(actions, []string{"VOLUME", "SNAPSHOT", "REASON", "STATE"}, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d objects were not deleted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package backup stores snapshot streams outside of storage appliance
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Writer stores stream once it is closed, aborted stream is discarded
+type Writer interface {
+	io.WriteCloser
+	Abort()
+}
+
+// Target keeps named streams
+//
+// Target is selected by URI: file:///dir keeps streams as files of local
+// directory, tar:///dir/archive.tar as entries of tar archive and
+// s3://bucket/prefix as objects of S3 compatible storage.
+type Target interface {
+	// Writer stores stream with given name once writer is closed
+	Writer(name string) (Writer, error)
+	// Reader provides stream with given name
+	Reader(name string) (io.ReadCloser, error)
+}
+
+// S3Cfg describes S3 compatible storage
+type S3Cfg struct {
+	Endpoint  string // host:port
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prot      string // https by default
+	PartSize  int64  // size of multipart upload part, 16MiB by default
+}
+
+// Config options of backup targets
+type Config struct {
+	S3     S3Cfg
+	TmpDir string   // directory for streams that have to be spooled
+	Roots  []string // directories local targets are allowed in
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateName makes sure name can not escape target
+func validateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("Stream name %s is incorrect", name)
+	}
+	return nil
+}
+
+// checkRoot makes sure local target is inside one of allowed roots
+func checkRoot(path string, roots []string) (string, error) {
+	if len(path) == 0 {
+		return "", fmt.Errorf("Path of backup target is not set")
+	}
+	path = filepath.Clean(path)
+	for _, root := range roots {
+		rel, err := filepath.Rel(filepath.Clean(root), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Backup target %s is not in allowed roots %v", path, roots)
+}
+
+// Open creates target described by URI
+//
+// Local targets have to be inside of roots given by config
+func Open(uri string, cfg Config) (Target, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Backup target %s is incorrect: %s", uri, err)
+	}
+
+	switch u.Scheme {
+	case "file", "tar":
+		if len(u.Host) > 0 {
+			return nil, fmt.Errorf("Backup target %s is incorrect, host is not expected", uri)
+		}
+		path, err := checkRoot(u.Path, cfg.Roots)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "file" {
+			return newFileTarget(path)
+		}
+		return newTarTarget(path, cfg.TmpDir)
+	case "s3":
+		return newS3Target(u.Host, u.Path, cfg.S3)
+	}
+	return nil, fmt.Errorf("Backup target type %s is not supported", u.Scheme)
+}
+
+// spool keeps stream in temporary file until it can be stored
+type spool struct {
+	f     *tmpFile
+	store func(f *tmpFile) error
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	return s.f.Write(p)
+}
+
+func (s *spool) Abort() {
+	s.f.remove()
+}
+
+func (s *spool) Close() error {
+	defer s.f.remove()
+	if err := s.f.rewind(); err != nil {
+		return err
+	}
+	return s.store(s.f)
+}
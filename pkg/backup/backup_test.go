@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStream makes stream of given size with non repeating content
+func testStream(size int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "%d,", i)
+	}
+	return b.Bytes()[:size]
+}
+
+// writeStream stores data in target writing it in chunks
+func writeStream(t *testing.T, target Target, name string, data []byte) {
+	w, err := target.Writer(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err = w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readStream(t *testing.T, target Target, name string) []byte {
+	r, err := target.Reader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testTarget checks that target keeps streams the same way memory target does
+func testTarget(t *testing.T, target Target) {
+	ref := newMemoryTarget()
+	streams := map[string][]byte{
+		"empty": {},
+		"small": testStream(100),
+		"large": testStream(10000),
+	}
+	for name, data := range streams {
+		writeStream(t, ref, name, data)
+		writeStream(t, target, name, data)
+	}
+	// Stream written again replaces previous one
+	writeStream(t, ref, "small", testStream(50))
+	writeStream(t, target, "small", testStream(50))
+
+	for name := range streams {
+		if !bytes.Equal(readStream(t, target, name), readStream(t, ref, name)) {
+			t.Errorf("stream %s differs", name)
+		}
+	}
+
+	w, err := target.Writer("aborted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(testStream(100))
+	w.Abort()
+	if r, err := target.Reader("aborted"); err == nil {
+		r.Close()
+		t.Error("aborted stream is stored")
+	}
+
+	if _, err = target.Writer("../escape"); err == nil {
+		t.Error("incorrect stream name is accepted")
+	}
+}
+
+func TestMemoryTarget(t *testing.T) {
+	testTarget(t, newMemoryTarget())
+}
+
+func TestFileTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target, err := Open("file://"+filepath.Join(dir, "streams"), Config{Roots: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTarget(t, target)
+}
+
+func TestTarTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target, err := Open("tar://"+filepath.Join(dir, "streams.tar"), Config{Roots: []string{dir}, TmpDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTarget(t, target)
+}
+
+// checkRootOf checks root of local target without creating it
+func checkRootOf(uri string, cfg Config) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return checkRoot(u.Path, cfg.Roots)
+}
+
+func TestOpenRoots(t *testing.T) {
+	cfg := Config{Roots: []string{"/backup", "/srv/streams/"}}
+
+	allowed := []string{
+		"file:///backup",
+		"file:///backup/daily",
+		"tar:///srv/streams/archive.tar",
+	}
+	for _, uri := range allowed {
+		if _, err := checkRootOf(uri, cfg); err != nil {
+			t.Errorf("%s is refused: %s", uri, err)
+		}
+	}
+
+	refused := []string{
+		"file:///",
+		"file:///backups",
+		"file:///backup/../etc",
+		"file://host/backup",
+		"tar:///srv/archive.tar",
+		"mem://test",
+	}
+	for _, uri := range refused {
+		if _, err := Open(uri, cfg); err == nil {
+			t.Errorf("%s is allowed", uri)
+		}
+	}
+
+	if _, err := Open("file:///backup", Config{}); err == nil || !strings.Contains(err.Error(), "allowed roots") {
+		t.Errorf("local target is allowed without roots: %v", err)
+	}
+}
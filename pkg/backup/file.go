@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tmpFile temporary file removed once stream is stored
+type tmpFile struct {
+	*os.File
+}
+
+func newTmpFile(dir string, prefix string) (*tmpFile, error) {
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &tmpFile{File: f}, nil
+}
+
+// rewind prepares file for reading of written data
+func (f *tmpFile) rewind() error {
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+func (f *tmpFile) size() (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (f *tmpFile) remove() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// fileTarget keeps streams as files of directory
+type fileTarget struct {
+	dir string
+}
+
+func newFileTarget(dir string) (*fileTarget, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("Directory of backup target is not set")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileTarget{dir: dir}, nil
+}
+
+// fileWriter writes into temporary file renamed on close,
+// so incomplete streams are never visible
+type fileWriter struct {
+	*os.File
+	path string
+}
+
+func (w *fileWriter) Abort() {
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
+
+func (w *fileWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return os.Rename(w.File.Name(), w.path)
+}
+
+func (t *fileTarget) Writer(name string) (Writer, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(t.dir, "."+name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f, path: filepath.Join(t.dir, name)}, nil
+}
+
+func (t *fileTarget) Reader(name string) (io.ReadCloser, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(t.dir, name))
+}
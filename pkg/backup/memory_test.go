@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// memoryTarget keeps streams in process memory, it is a reference
+// all other targets are compared with
+type memoryTarget struct {
+	access  sync.Mutex
+	streams map[string][]byte
+}
+
+func newMemoryTarget() *memoryTarget {
+	return &memoryTarget{streams: map[string][]byte{}}
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	store func(data []byte)
+}
+
+func (w *memoryWriter) Abort() {}
+
+func (w *memoryWriter) Close() error {
+	w.store(w.Bytes())
+	return nil
+}
+
+func (t *memoryTarget) Writer(name string) (Writer, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	return &memoryWriter{store: func(data []byte) {
+		t.access.Lock()
+		t.streams[name] = data
+		t.access.Unlock()
+	}}, nil
+}
+
+func (t *memoryTarget) Reader(name string) (io.ReadCloser, error) {
+	t.access.Lock()
+	defer t.access.Unlock()
+
+	data, ok := t.streams[name]
+	if !ok {
+		return nil, fmt.Errorf("Stream %s not found", name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
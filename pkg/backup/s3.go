@@ -0,0 +1,308 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 requests are signed with AWS signature version 4,
+// payload is not signed, so streams are not read twice.
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeLayout      = "20060102T150405Z"
+	s3DateLayout      = "20060102"
+	s3DefaultRegion   = "us-east-1"
+	s3DefaultPartSize = 16 << 20
+)
+
+// s3Target keeps streams as objects of a bucket, path style
+// addressing is used, so it works with MinIO and similar storages
+//
+// Streams are stored with multipart upload, part is kept in memory
+// until it is full, so nothing is spooled to disk.
+type s3Target struct {
+	cfg    S3Cfg
+	bucket string
+	prefix string
+	client *http.Client
+}
+
+func newS3Target(bucket string, prefix string, cfg S3Cfg) (*s3Target, error) {
+	if len(bucket) == 0 {
+		return nil, fmt.Errorf("Bucket of backup target is not set")
+	}
+	if len(cfg.Endpoint) == 0 {
+		return nil, fmt.Errorf("S3 endpoint is not set")
+	}
+	if len(cfg.Region) == 0 {
+		cfg.Region = s3DefaultRegion
+	}
+	if len(cfg.Prot) == 0 {
+		cfg.Prot = "https"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = s3DefaultPartSize
+	}
+	return &s3Target{
+		cfg:    cfg,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		client: &http.Client{},
+	}, nil
+}
+
+// objectPath returns path of object with given name
+func (t *s3Target) objectPath(name string) string {
+	p := "/" + t.bucket + "/"
+	if len(t.prefix) > 0 {
+		p += t.prefix + "/"
+	}
+	return p + name
+}
+
+// s3Part uploaded part of object
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+// s3Writer uploads stream part by part
+//
+// Upload starts with the first full part, shorter streams are
+// stored with a single request on close.
+type s3Writer struct {
+	t        *s3Target
+	path     string
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	err      error
+}
+
+func (t *s3Target) Writer(name string) (Writer, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	return &s3Writer{t: t, path: t.objectPath(name)}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.t.cfg.PartSize {
+		if w.err = w.uploadPart(w.buf.Next(int(w.t.cfg.PartSize))); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// uploadPart sends part, starting upload if needed
+func (w *s3Writer) uploadPart(data []byte) error {
+	if len(w.uploadID) == 0 {
+		var res struct {
+			UploadID string `xml:"UploadId"`
+		}
+		q := url.Values{"uploads": {""}}
+		if err := w.t.doXML("POST", w.path, q, nil, &res); err != nil {
+			return err
+		}
+		w.uploadID = res.UploadID
+	}
+
+	n := len(w.parts) + 1
+	q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {w.uploadID}}
+	rsp, err := w.t.do("PUT", w.path, q, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	w.parts = append(w.parts, s3Part{Number: n, ETag: rsp.Header.Get("ETag")})
+	return nil
+}
+
+func (w *s3Writer) Abort() {
+	if len(w.uploadID) > 0 {
+		q := url.Values{"uploadId": {w.uploadID}}
+		if rsp, err := w.t.do("DELETE", w.path, q, nil, 0); err == nil {
+			rsp.Body.Close()
+		}
+	}
+	w.err = fmt.Errorf("Upload of %s is aborted", w.path)
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+
+	if len(w.uploadID) == 0 {
+		rsp, err := w.t.do("PUT", w.path, nil, bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()))
+		if err != nil {
+			return err
+		}
+		rsp.Body.Close()
+		return nil
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts}
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	q := url.Values{"uploadId": {w.uploadID}}
+	if err = w.t.doXML("POST", w.path, q, data, nil); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+func (t *s3Target) Reader(name string) (io.ReadCloser, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	rsp, err := t.do("GET", t.objectPath(name), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Body, nil
+}
+
+// doXML sends request and decodes XML response into res, if given
+//
+// Completion of multipart upload might fail with status 200,
+// so error element of response is checked as well
+func (t *s3Target) doXML(method string, path string, q url.Values, body []byte, res interface{}) error {
+	rsp, err := t.do(method, path, q, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	var s3Err struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 %s %s failed: %s %s", method, path, s3Err.Code, s3Err.Message)
+	}
+	if res == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, res)
+}
+
+// do sends signed request, response with error status is turned into error
+func (t *s3Target) do(method string, path string, q url.Values, body io.Reader, size int64) (*http.Response, error) {
+	addr := fmt.Sprintf("%s://%s%s", t.cfg.Prot, t.cfg.Endpoint, s3Escape(path))
+	req, err := http.NewRequest(method, addr, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	// Encoded query is sorted, as canonical request requires
+	req.URL.RawQuery = q.Encode()
+	t.sign(req, time.Now().UTC())
+
+	rsp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+		rsp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s failed with code %d: %s", method, path, rsp.StatusCode, msg)
+	}
+	return rsp, nil
+}
+
+// sign adds AWS signature version 4 to request
+func (t *s3Target) sign(req *http.Request, now time.Time) {
+	amzTime := now.Format(s3TimeLayout)
+	date := now.Format(s3DateLayout)
+
+	req.Header.Set("X-Amz-Date", amzTime)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + s3UnsignedPayload,
+		"x-amz-date:" + amzTime,
+		"",
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	scope := strings.Join([]string{date, t.cfg.Region, "s3", "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{s3Algorithm, amzTime, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+t.cfg.SecretKey), date)
+	key = hmacSHA256(key, t.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, t.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes path as required by signature, keeping slashes
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is S3 compatible storage keeping objects in memory
+type fakeS3 struct {
+	access   sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	nextID   int
+	complete int // multipart uploads completed
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	path := r.URL.Path
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "POST" && q.Get("uploadId") == "" && q["uploads"] != nil:
+		s.nextID++
+		id := fmt.Sprintf("upload+%d/", s.nextID)
+		s.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		parts, ok := s.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"etag%d\"", n))
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		parts, ok := s.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Parts []s3Part `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := []byte{}
+		for i, p := range req.Parts {
+			if p.Number != i+1 || p.ETag != fmt.Sprintf("\"etag%d\"", p.Number) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			data = append(data, parts[p.Number]...)
+		}
+		s.objects[path] = data
+		delete(s.uploads, q.Get("uploadId"))
+		s.complete++
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		s.objects[path] = body
+	case r.Method == "GET":
+		data, ok := s.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestS3Target(t *testing.T) {
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	cfg := Config{S3: S3Cfg{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		AccessKey: "access",
+		SecretKey: "secret",
+		Prot:      "http",
+		PartSize:  4096,
+	}}
+	target, err := Open("s3://bucket/prefix", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTarget(t, target)
+
+	// Abort of started upload discards uploaded parts
+	w, err := target.Writer("partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(testStream(5000)); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+
+	if s3.complete != 1 {
+		t.Errorf("expected 1 multipart upload, got %d", s3.complete)
+	}
+	if len(s3.uploads) != 0 {
+		t.Errorf("aborted uploads are left: %v", s3.uploads)
+	}
+	if _, ok := s3.objects["/bucket/prefix/large"]; !ok {
+		t.Errorf("object is not stored under prefix")
+	}
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tarTrailerSize two zero blocks ending tar archive
+const tarTrailerSize = 2 * 512
+
+// tarTarget keeps streams as entries of tar archive
+//
+// Size of entry is written before its data, so stream is spooled
+// to temporary file first. New entries are appended to archive.
+type tarTarget struct {
+	path   string
+	tmpDir string
+	access sync.Mutex
+}
+
+func newTarTarget(path string, tmpDir string) (*tarTarget, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("Archive of backup target is not set")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &tarTarget{path: path, tmpDir: tmpDir}, nil
+}
+
+func (t *tarTarget) Writer(name string) (Writer, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	f, err := newTmpFile(t.tmpDir, name)
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f, store: func(f *tmpFile) error {
+		return t.append(name, f)
+	}}, nil
+}
+
+// append adds entry to the end of archive, overwriting its trailer
+func (t *tarTarget) append(name string, f *tmpFile) error {
+	size, err := f.size()
+	if err != nil {
+		return err
+	}
+
+	t.access.Lock()
+	defer t.access.Unlock()
+
+	a, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	st, err := a.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() >= tarTrailerSize {
+		if _, err = a.Seek(-tarTrailerSize, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+
+	tw := tar.NewWriter(a)
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err = io.Copy(tw, f); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// tarEntry reads entry of archive
+type tarEntry struct {
+	io.Reader
+	f *os.File
+}
+
+func (e *tarEntry) Close() error {
+	return e.f.Close()
+}
+
+func (t *tarTarget) Reader(name string) (io.ReadCloser, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	// The latest entry with the name wins, as in tar itself
+	last := -1
+	if err := t.walk(func(i int, hdr *tar.Header) {
+		if hdr.Name == name {
+			last = i
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("Stream %s not found in %s", name, t.path)
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(f)
+	for i := 0; i <= last; i++ {
+		if _, err = tr.Next(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &tarEntry{Reader: tr, f: f}, nil
+}
+
+// walk calls fn for every entry of archive
+func (t *tarTarget) walk(fn func(i int, hdr *tar.Header)) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(i, hdr)
+	}
+}
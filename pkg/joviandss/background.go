@@ -0,0 +1,109 @@
+package joviandss
+
+import (
+	"strings"
+)
+
+// Snapshot policies and replication are run by controller itself,
+// so only one controller of an instance may run at a time, several
+// ones would make duplicate snapshots and send them concurrently.
+//
+// Loops are started only when there is something to do: policy in
+// controller config or volume with the parameter, they run until
+// controller stops. Parameters of volumes are read once and cached,
+// they do not change once volume is created.
+
+// volumeParameters returns storage class parameters volume was created with,
+// nil if volume has no record
+func (cp *ControllerPlugin) volumeParameters(vID string) (map[string]string, error) {
+	cp.paramsAccess.Lock()
+	params, ok := cp.params[vID]
+	cp.paramsAccess.Unlock()
+	if ok {
+		return params, nil
+	}
+
+	rec, err := cp.meta.GetVolume(vID)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	params = rec.Parameters
+	if params == nil {
+		params = map[string]string{}
+	}
+	cp.cacheVolumeParameters(vID, params)
+	return params, nil
+}
+
+// cacheVolumeParameters keeps parameters of volume, nil forgets them
+func (cp *ControllerPlugin) cacheVolumeParameters(vID string, params map[string]string) {
+	cp.paramsAccess.Lock()
+	defer cp.paramsAccess.Unlock()
+
+	if params == nil {
+		delete(cp.params, vID)
+		return
+	}
+	cp.params[vID] = params
+}
+
+// anyVolumeHas checks if some volume of instance has parameter set,
+// volumes that can not be checked are assumed to have it
+func (cp *ControllerPlugin) anyVolumeHas(key string) bool {
+	l := cp.l.WithField("func", "anyVolumeHas")
+
+	vnames, rErr := (*cp.endpoints[0]).ListVolumes()
+	if rErr != nil {
+		l.Warnf("Unable to list volumes: %s", rErr.Error())
+		return true
+	}
+
+	found := false
+	for _, vID := range vnames {
+		if !cp.ownsVolume(vID) || strings.HasPrefix(vID, "c_") || isNFSVolume(vID) {
+			continue
+		}
+		params, err := cp.volumeParameters(vID)
+		if err != nil {
+			l.Warnf("Unable to get parameters of volume %s: %s", vID, err.Error())
+			found = true
+			continue
+		}
+		if v := params[key]; len(v) > 0 && v != snapshotPolicyNone {
+			found = true
+		}
+	}
+	return found
+}
+
+// startBackgroundTasks starts loops volumes of instance need
+func (cp *ControllerPlugin) startBackgroundTasks() {
+	if len(cp.cfg.SnapshotPolicy) > 0 || cp.anyVolumeHas(paramSnapshotPolicy) {
+		cp.startSnapshotPolicies()
+	}
+	if cp.replica != nil && cp.anyVolumeHas(paramReplicationInterval) {
+		cp.startReplication()
+	}
+}
+
+// startVolumeTasks starts loops new volume needs
+func (cp *ControllerPlugin) startVolumeTasks(params map[string]string) {
+	if p := params[paramSnapshotPolicy]; len(p) > 0 && p != snapshotPolicyNone {
+		cp.startSnapshotPolicies()
+	}
+	if cp.replica != nil && len(params[paramReplicationInterval]) > 0 {
+		cp.startReplication()
+	}
+}
+
+func (cp *ControllerPlugin) startSnapshotPolicies() {
+	cp.policiesOnce.Do(func() {
+		go cp.runSnapshotPolicies()
+	})
+}
+
+func (cp *ControllerPlugin) startReplication() {
+	cp.replicationOnce.Do(func() {
+		go cp.runReplication()
+	})
+}
@@ -0,0 +1,65 @@
+package joviandss
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+const (
+	chapNameLen = 12
+	chapPassLen = 16
+
+	// ChapSecretKey name of the key in CSI secrets that holds CHAP secret
+	ChapSecretKey = "chapsecret"
+)
+
+// chapCredentials holds CHAP credentials for a particular volume and node
+//
+// User and Pass are used by initiator to authenticate itself to target,
+// TUser and TPass are used by target to authenticate itself to initiator
+type chapCredentials struct {
+	User  string
+	Pass  string
+	TUser string
+	TPass string
+}
+
+// getChapSecret selects secret provided with CSI secrets over the configured one
+func getChapSecret(secrets map[string]string, cfgSecret string) string {
+	if s := secrets[ChapSecretKey]; len(s) > 0 {
+		return s
+	}
+	return cfgSecret
+}
+
+// getChapCredentials derives CHAP credentials from shared secret
+//
+// Controller and node calculate same credentials independently,
+// so credentials never have to be passed along with publish context.
+// Initiator credentials are unique for every node, while target
+// credentials are shared by all nodes the volume is published to.
+func getChapCredentials(secret string, vname string, nID string) chapCredentials {
+	const nameChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ01234567"
+	const passChars = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@"
+
+	return chapCredentials{
+		User:  deriveChapString(secret, "user:"+vname+":"+nID, nameChars, 31, chapNameLen),
+		Pass:  deriveChapString(secret, "pass:"+vname+":"+nID, passChars, 63, chapPassLen),
+		TUser: deriveChapString(secret, "tuser:"+vname, nameChars, 31, chapNameLen),
+		TPass: deriveChapString(secret, "tpass:"+vname, passChars, 63, chapPassLen),
+	}
+}
+
+// deriveChapString makes string of length l out of HMAC of label
+func deriveChapString(secret string, label string, chars string, mask byte, l int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(label))
+	sum := mac.Sum(nil)
+
+	out := make([]byte, l)
+	for i := 0; i < l; i++ {
+		out[i] = chars[sum[i%len(sum)]&mask]
+	}
+	return string(out)
+}
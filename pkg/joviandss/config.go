@@ -4,6 +4,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/backup"
 	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
 )
 
@@ -13,13 +14,32 @@ type ControllerCfg struct {
 	Vnamelen         int
 	Vpasslen         int
 	Nodeprefix       string
+	Instance         string
 	Iqn              string
+	Nqn              string
+	ChapSecret       string
+
+	SnapshotPolicy      string // default policy of volumes with no snapshotPolicy parameter
+	ListPolicySnapshots bool   // include policy snapshots in ListSnapshots
+
+	Replica rest.StorageCfg // secondary storage volumes are replicated to
+
+	Backup backup.Config // options of export and import targets
+
+	KMS KMSCfg // keeps keys of volumes with encryption kms
+
+	NfsNodes map[string]string // addresses nodes access NFS shares from, by node id
 }
 
 type NodeCfg struct {
-	Id   string
-	Addr string
-	Port int
+	Id         string
+	Addr       string
+	Port       int
+	ChapSecret string
+	FsType     string
+	KubeletDir string
+	Iqn        string
+	NvmePort   int
 }
 
 type Config struct {
@@ -0,0 +1,238 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/backup"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Snapshot data is streamed out of storage with send API and stored
+// in backup target, import receives such stream as a new volume.
+// Both take long, so they run as operations and RPCs return Aborted
+// with progress until they are done.
+
+// progressWriter counts bytes written and reports progress of operation
+type progressWriter struct {
+	op      *operation
+	total   int64
+	written int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.total > 0 {
+		progress := int(w.written * 100 / w.total)
+		if progress > 99 {
+			progress = 99
+		}
+		w.op.setProgress(progress)
+	}
+	return len(p), nil
+}
+
+// ExportSnapshot stores snapshot data in backup target
+func (cp *ControllerPlugin) ExportSnapshot(ctx context.Context, req *ExportSnapshotRequest) (*ExportSnapshotResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "ExportSnapshot",
+	})
+
+	sID := req.SnapshotID
+	if len(sID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot id missing in request")
+	}
+	if len(req.Target) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Backup target missing in request")
+	}
+	if strings.HasPrefix(sID, "c_") || !cp.ownsSnapshot(sID) {
+		msg := fmt.Sprintf("Snapshot %s belongs to other instance", sID)
+		l.Warn(msg)
+		return nil, status.Error(codes.NotFound, msg)
+	}
+	name := req.Name
+	if len(name) == 0 {
+		name = sID
+	}
+
+	s, err := cp.getSnapshot(sID)
+	if err != nil {
+		return nil, err
+	}
+	size, _ := strconv.ParseInt(s.Referenced, 10, 64)
+
+	target, err := backup.Open(req.Target, cp.cfg.Backup)
+	if err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	op := cp.ops.run(ctx, "ExportSnapshot/"+req.Target+"/"+name, func(op *operation) error {
+		w, err := target.Writer(name)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		stream, rErr := (*cp.endpoints[0]).SendSnapshot(strings.Split(sID, "_")[0], sID)
+		if rErr != nil {
+			w.Abort()
+			switch rErr.GetCode() {
+			case rest.RestResourceDNE:
+				return status.Error(codes.NotFound, rErr.Error())
+			default:
+				return status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+		defer stream.Close()
+
+		pw := &progressWriter{op: op, total: size}
+		if _, err = io.Copy(w, io.TeeReader(stream, pw)); err != nil {
+			w.Abort()
+			return status.Errorf(codes.Internal, "Unable to export snapshot %s: %s", sID, err)
+		}
+		if err = w.Close(); err != nil {
+			return status.Errorf(codes.Internal, "Unable to store snapshot %s: %s", sID, err)
+		}
+		return nil
+	})
+	if !op.Done() {
+		msg := fmt.Sprintf("Export of snapshot %s is in progress: %d%%", sID, op.Progress())
+		l.Trace(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	if err = op.Err(); err != nil {
+		return nil, err
+	}
+
+	l.Tracef("Snapshot %s exported to %s as %s", sID, req.Target, name)
+	return &ExportSnapshotResponse{Target: req.Target, Name: name}, nil
+}
+
+// ImportVolume creates volume out of stream stored in backup target
+//
+// Imported volume belongs to plugin instance, so it can be used
+// as content source of CreateVolume.
+func (cp *ControllerPlugin) ImportVolume(ctx context.Context, req *ImportVolumeRequest) (*ImportVolumeResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "ImportVolume",
+	})
+
+	if len(req.Name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume name missing in request")
+	}
+	if len(req.Source) == 0 || len(req.Stream) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Backup source missing in request")
+	}
+
+	source, err := backup.Open(req.Source, cp.cfg.Backup)
+	if err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	vID := cp.volumeID(req.Name)
+
+	op := cp.ops.run(ctx, "ImportVolume/"+req.Name, func(op *operation) error {
+		if err := cp.lockVolume(vID); err != nil {
+			return err
+		}
+		defer cp.unlockVolume(vID)
+
+		rec, err := cp.meta.GetVolume(vID)
+		if err != nil {
+			return err
+		}
+		if rec != nil {
+			// Retry of finished import
+			if rec.Name == req.Name {
+				return nil
+			}
+			msg := fmt.Sprintf("Volume %s exists and is not imported", vID)
+			l.Warn(msg)
+			return status.Error(codes.AlreadyExists, msg)
+		}
+
+		// Volume received by import interrupted before it was registered
+		if _, rErr := (*cp.endpoints[0]).GetVolume(vID); rErr == nil {
+			return cp.finishImport(l, vID, req.Name)
+		} else if rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+
+		return cp.importVolume(l, vID, req.Name, source, req.Stream)
+	})
+	if !op.Done() {
+		msg := fmt.Sprintf("Import of volume %s is in progress", vID)
+		l.Trace(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	if err = op.Err(); err != nil {
+		return nil, err
+	}
+
+	vSize, err := cp.getVolumeSize(vID)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportVolumeResponse{VolumeID: vID, CapacityBytes: vSize}, nil
+}
+
+// importVolume receives stream as locked volume and registers it
+func (cp *ControllerPlugin) importVolume(l *logrus.Entry, vID string, name string,
+	source backup.Target, stream string) error {
+
+	r, err := source.Reader(stream)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "Unable to read stream %s: %s", stream, err)
+	}
+	defer r.Close()
+
+	if rErr := (*cp.endpoints[0]).ReceiveVolume(vID, r); rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestObjectExists:
+			msg := fmt.Sprintf("Volume %s exists and is not imported", vID)
+			l.Warn(msg)
+			return status.Error(codes.AlreadyExists, msg)
+		default:
+			// Incomplete volume is removed, so import can be retried
+			(*cp.endpoints[0]).DeleteVolume(vID, true)
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	l.Tracef("Volume %s received from stream %s", vID, stream)
+	return cp.finishImport(l, vID, name)
+}
+
+// finishImport removes snapshots received with volume and registers it
+func (cp *ControllerPlugin) finishImport(l *logrus.Entry, vID string, name string) error {
+	// Snapshot stream was made of is received along with data
+	snapshots, err := cp.getVolumeAllSnapshots(vID)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		rErr := (*cp.endpoints[0]).DeleteSnapshot(vID, s.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, "Unable to delete snapshot %s of %s: %s",
+				s.Name, vID, rErr.Error())
+		}
+	}
+
+	l.Tracef("Volume %s imported", vID)
+	return cp.meta.PutVolume(VolumeRecord{
+		ID:   vID,
+		Name: name,
+		Ownership: Ownership{
+			Instance: cp.instance,
+			Created:  time.Now().UTC(),
+		},
+	})
+}
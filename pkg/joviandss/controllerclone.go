@@ -0,0 +1,363 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Volumes made of other volumes and deleted volumes with clones form
+// chains of ZFS clones, parents can not be freed while clones exist.
+// Flatten replaces volume with its full copy, so parents that
+// are concealed can be garbage collected.
+//
+// Flatten copies volume into <volume>_flat from c_<volume>_flatten snapshot,
+// once copy is done volume is replaced with the copy.
+
+const (
+	// FlattenRunning copy of volume is in progress
+	FlattenRunning = "running"
+	// FlattenDone volume is independent of other volumes
+	FlattenDone = "done"
+
+	flatVolumeSuffix = "_flat"
+	flattenSnapshot  = "flatten"
+)
+
+func flattenSnapshotName(vID string) string {
+	return fmt.Sprintf("c_%s_%s", vID, flattenSnapshot)
+}
+
+// getAncestry lists snapshots volume is cloned from, nearest first
+func (cp *ControllerPlugin) getAncestry(vID string) ([]VolumeAncestor, error) {
+	out := []VolumeAncestor{}
+	seen := map[string]bool{}
+
+	for cur := storageVolume(vID); !seen[cur]; {
+		seen[cur] = true
+
+		v, err := cp.getVolume(cur)
+		if err != nil {
+			return nil, err
+		}
+		if !v.IsClone {
+			break
+		}
+
+		or, err := parseOrigin(v.Origin)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, VolumeAncestor{
+			Volume:    or.Volume,
+			Snapshot:  or.Snapshot,
+			Concealed: strings.HasPrefix(or.Volume, "c_") || strings.HasPrefix(or.Snapshot, "c_"),
+		})
+		cur = or.Volume
+	}
+	return out, nil
+}
+
+// GetVolumeAncestry provides clone chain of volume
+func (cp *ControllerPlugin) GetVolumeAncestry(ctx context.Context, req *GetVolumeAncestryRequest) (*GetVolumeAncestryResponse, error) {
+	vID := req.VolumeID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if isNFSVolume(vID) || (!cp.ownsVolume(vID) && !isStaticVolume(vID)) {
+		msg := fmt.Sprintf("Volume %s has no clone chain", vID)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	ancestors, err := cp.getAncestry(vID)
+	if err != nil {
+		return nil, err
+	}
+	return &GetVolumeAncestryResponse{
+		VolumeID:  vID,
+		Ancestors: ancestors,
+	}, nil
+}
+
+// FlattenVolume starts or continues making volume independent of its ancestors
+func (cp *ControllerPlugin) FlattenVolume(ctx context.Context, req *FlattenVolumeRequest) (*FlattenVolumeResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "FlattenVolume",
+	})
+
+	vID := req.VolumeID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if isNFSVolume(vID) || strings.HasPrefix(vID, "c_") || !cp.ownsVolume(vID) {
+		msg := fmt.Sprintf("Volume %s can not be flattened", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if err := cp.lockVolume(vID); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vID)
+
+	return cp.flattenVolume(l, vID)
+}
+
+// flattenInProgress checks if volume has copy made by flatten
+//
+// Flatten snapshot marks volume being flattened, copy state is asked
+// only then, so storage without copy support is never asked for it
+func (cp *ControllerPlugin) flattenInProgress(vID string) (bool, error) {
+	_, rErr := (*cp.endpoints[0]).GetSnapshot(vID, flattenSnapshotName(vID))
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return false, nil
+		}
+		return false, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	_, rErr = (*cp.endpoints[0]).GetVolumeCopy(vID + flatVolumeSuffix)
+	if rErr == nil {
+		return true, nil
+	}
+	if rErr.GetCode() == rest.RestResourceDNE {
+		return false, nil
+	}
+	return false, status.Errorf(codes.Internal, rErr.Error())
+}
+
+// checkNotFlattening refuses access to volume that is being flattened,
+// data written to it would be lost once it is replaced with the copy
+func (cp *ControllerPlugin) checkNotFlattening(vID string) error {
+	inProgress, err := cp.flattenInProgress(vID)
+	if err != nil {
+		return err
+	}
+	if inProgress {
+		return status.Errorf(codes.FailedPrecondition, "Volume %s is being flattened", vID)
+	}
+	return nil
+}
+
+// writtenSinceFlatten checks if volume was modified after flatten snapshot
+//
+// Written property of volume and snapshots counts data written
+// since previous snapshot, all of them have to be zero
+func (cp *ControllerPlugin) writtenSinceFlatten(vID string, v *rest.Volume) (bool, error) {
+	if w, _ := strconv.ParseInt(v.Written, 10, 64); w > 0 {
+		return true, nil
+	}
+
+	fs, rErr := (*cp.endpoints[0]).GetSnapshot(vID, flattenSnapshotName(vID))
+	if rErr != nil {
+		return false, status.Errorf(codes.Internal, rErr.Error())
+	}
+	created, rErr := rest.GetTimeStamp(fs.Creation)
+	if rErr != nil {
+		return false, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	snaps, err := cp.getVolumeAllSnapshots(vID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range snaps {
+		if s.Name == flattenSnapshotName(vID) {
+			continue
+		}
+		t, rErr := rest.GetTimeStamp(s.Properties.Creation)
+		if rErr != nil {
+			return false, status.Errorf(codes.Internal, rErr.Error())
+		}
+		if t < created {
+			continue
+		}
+		ns, rErr := (*cp.endpoints[0]).GetSnapshot(vID, s.Name)
+		if rErr != nil {
+			return false, status.Errorf(codes.Internal, rErr.Error())
+		}
+		if w, _ := strconv.ParseInt(ns.Written, 10, 64); w > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkUnpublished makes sure volume has no iSCSI target or NVMe subsystem
+func (cp *ControllerPlugin) checkUnpublished(vID string) error {
+	tname := fmt.Sprintf("%s:%s", cp.iqn, vID)
+	if _, rErr := (*cp.endpoints[0]).GetTarget(tname); rErr == nil {
+		return status.Errorf(codes.FailedPrecondition, "Volume %s is published", vID)
+	} else if rErr.GetCode() != rest.RestResourceDNE {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	sname := fmt.Sprintf("%s:%s", cp.nqn, vID)
+	if _, rErr := (*cp.endpoints[0]).GetNVMeSubsystem(sname); rErr == nil {
+		return status.Errorf(codes.FailedPrecondition, "Volume %s is published", vID)
+	} else if rErr.GetCode() != rest.RestResourceDNE {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+	return nil
+}
+
+// checkFlattenable makes sure volume is not used, so it can be replaced
+func (cp *ControllerPlugin) checkFlattenable(vID string) error {
+	if err := cp.checkUnpublished(vID); err != nil {
+		return err
+	}
+
+	snaps, err := cp.getVolumeAllSnapshots(vID)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s.Name == flattenSnapshotName(vID) {
+			continue
+		}
+		if !strings.HasPrefix(s.Name, "c_") {
+			return status.Errorf(codes.FailedPrecondition, "Volume %s has snapshot %s", vID, s.Name)
+		}
+		if len(s.Clones) > 0 {
+			return status.Errorf(codes.FailedPrecondition, "Volume %s has clones %s", vID, s.Clones)
+		}
+	}
+	return nil
+}
+
+// flattenVolume moves flatten of locked volume to the next stage
+func (cp *ControllerPlugin) flattenVolume(l *logrus.Entry, vID string) (*FlattenVolumeResponse, error) {
+	flat := vID + flatVolumeSuffix
+	fsname := flattenSnapshotName(vID)
+
+	v, err := cp.getVolume(vID)
+	if status.Code(err) == codes.NotFound {
+		// Volume is deleted once copy is done, only renaming is left
+		return cp.completeFlatten(l, vID, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, rErr := (*cp.endpoints[0]).GetVolumeCopy(flat)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	if c == nil {
+		if !v.IsClone {
+			return &FlattenVolumeResponse{State: FlattenDone, Progress: 100}, nil
+		}
+		if err = cp.checkFlattenable(vID); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+
+		rErr = (*cp.endpoints[0]).CreateSnapshot(vID, fsname)
+		if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		rErr = (*cp.endpoints[0]).CopyVolume(vID, fsname, flat)
+		if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		l.Tracef("Copy of volume %s to %s started", vID, flat)
+		return &FlattenVolumeResponse{State: FlattenRunning}, nil
+	}
+
+	switch c.State {
+	case rest.VolumeCopyDone:
+		return cp.completeFlatten(l, vID, v)
+	case rest.VolumeCopyFailed:
+		// Clean up, so next request starts over
+		(*cp.endpoints[0]).DeleteVolume(flat, true)
+		(*cp.endpoints[0]).DeleteSnapshot(vID, fsname)
+		msg := fmt.Sprintf("Copy of volume %s failed: %s", vID, c.Message)
+		l.Warn(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+
+	return &FlattenVolumeResponse{State: FlattenRunning, Progress: c.Progress}, nil
+}
+
+// completeFlatten replaces volume with its copy and frees concealed parents
+//
+// Record of volume is stored on copy before volume is deleted, so it is
+// renamed along with copy. v is nil if volume was already deleted.
+func (cp *ControllerPlugin) completeFlatten(l *logrus.Entry, vID string, v *rest.Volume) (*FlattenVolumeResponse, error) {
+	flat := vID + flatVolumeSuffix
+
+	var or *origin
+	if v != nil {
+		// Volume could be published while copy was in progress
+		err := cp.checkFlattenable(vID)
+		if err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+
+		// Copy lacks data written after flatten snapshot, start over
+		if written, err := cp.writtenSinceFlatten(vID, v); err != nil {
+			return nil, err
+		} else if written {
+			(*cp.endpoints[0]).DeleteVolume(flat, true)
+			(*cp.endpoints[0]).DeleteSnapshot(vID, flattenSnapshotName(vID))
+			msg := fmt.Sprintf("Volume %s was modified during flatten, copy is discarded", vID)
+			l.Warn(msg)
+			return nil, status.Error(codes.Aborted, msg)
+		}
+
+		rec, err := cp.meta.GetVolume(vID)
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			rec.ID = flat
+			if err = cp.meta.PutVolume(*rec); err != nil {
+				return nil, err
+			}
+		}
+		if v.IsClone {
+			if or, err = parseOrigin(v.Origin); err != nil {
+				return nil, err
+			}
+		}
+
+		rErr := (*cp.endpoints[0]).DeleteVolume(vID, true)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rErr := (*cp.endpoints[0]).RenameVolume(flat, vID)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE && v == nil {
+			return nil, status.Errorf(codes.NotFound, "Volume %s not found", vID)
+		}
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	// Snapshot is copied along with data
+	rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, flattenSnapshotName(vID))
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		l.Warnf("Unable to delete flatten snapshot of %s: %s", vID, rErr.Error())
+	}
+
+	if or != nil && strings.HasPrefix(or.Snapshot, "c_") {
+		rErr = (*cp.endpoints[0]).DeleteSnapshot(or.Volume, or.Snapshot)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			l.Warnf("Unable to delete snapshot %s: %s", or.Snapshot, rErr.Error())
+		}
+		// Try to remove parents if they are concealed
+		cp.gcVolume(or.Volume)
+	}
+
+	l.Tracef("Volume %s flattened", vID)
+	return &FlattenVolumeResponse{State: FlattenDone, Progress: 100}, nil
+}
@@ -0,0 +1,193 @@
+package joviandss
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Volumes made from other volumes or snapshots are ZFS clones by default.
+// With cloneMode full new volume is an independent copy of the source.
+// Copy is made by storage in background, CreateVolume reports progress
+// with Aborted code, so provisioner retries until copy is done.
+//
+// Volume is copied from its c_<volume>_copy-<new volume> snapshot,
+// snapshot source is copied directly.
+
+const (
+	// CloneModeClone new volume is ZFS clone of the source
+	CloneModeClone = "clone"
+	// CloneModeFull new volume is full copy of the source
+	CloneModeFull = "full"
+
+	copySnapshotPrefix = "copy-"
+)
+
+// isCloneModeSupported checks value of cloneMode parameter
+func isCloneModeSupported(mode string) bool {
+	switch mode {
+	case "", CloneModeClone, CloneModeFull:
+		return true
+	}
+	return false
+}
+
+func copySnapshotName(vID string, nvID string) string {
+	return fmt.Sprintf("c_%s_%s%s", vID, copySnapshotPrefix, nvID)
+}
+
+// copyDestination returns volume that is copied from snapshot
+func copyDestination(vID string, sname string) string {
+	return strings.TrimPrefix(sname, fmt.Sprintf("c_%s_%s", vID, copySnapshotPrefix))
+}
+
+// isCopySnapshot checks if snapshot is source of volume copy
+func isCopySnapshot(vID string, sname string) bool {
+	return strings.HasPrefix(sname, fmt.Sprintf("c_%s_%s", vID, copySnapshotPrefix))
+}
+
+// copyRunning checks if copy into volume is in progress
+func (cp *ControllerPlugin) copyRunning(vID string) (bool, error) {
+	c, rErr := (*cp.endpoints[0]).GetVolumeCopy(vID)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return false, nil
+		}
+		return false, status.Errorf(codes.Internal, rErr.Error())
+	}
+	return c.State == rest.VolumeCopyRunning, nil
+}
+
+// createVolumeCopy starts or continues making full copy of source
+//
+// Returns response once copy is done, Aborted error while it is running
+func (cp *ControllerPlugin) createVolumeCopy(l *logrus.Entry,
+	req *csi.CreateVolumeRequest,
+	out *csi.CreateVolumeResponse,
+	nvID string,
+	srcVolume string,
+	srcSnapshot string) (*csi.CreateVolumeResponse, error) {
+
+	c, rErr := (*cp.endpoints[0]).GetVolumeCopy(nvID)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	if c == nil {
+		if _, err := cp.getVolume(nvID); err == nil {
+			// Volume exists, but was not made by copy
+			msg := fmt.Sprintf("Volume %s exists and is not a copy", nvID)
+			l.Warn(msg)
+			return nil, status.Error(codes.AlreadyExists, msg)
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+		return nil, cp.startVolumeCopy(l, nvID, srcVolume, srcSnapshot)
+	}
+
+	switch c.State {
+	case rest.VolumeCopyDone:
+		return cp.completeVolumeCopy(l, req, out, nvID, c)
+	case rest.VolumeCopyFailed:
+		// Clean up, so next request starts over
+		(*cp.endpoints[0]).DeleteVolume(nvID, true)
+		if len(srcVolume) > 0 {
+			(*cp.endpoints[0]).DeleteSnapshot(srcVolume, copySnapshotName(srcVolume, nvID))
+		}
+		msg := fmt.Sprintf("Copy of volume %s failed: %s", nvID, c.Message)
+		l.Warn(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+
+	msg := fmt.Sprintf("Copy of volume %s is in progress: %d%%", nvID, c.Progress)
+	l.Trace(msg)
+	return nil, status.Error(codes.Aborted, msg)
+}
+
+// startVolumeCopy starts copy of snapshot or volume into new volume
+func (cp *ControllerPlugin) startVolumeCopy(l *logrus.Entry, nvID string, srcVolume string, srcSnapshot string) error {
+	var vname, sname string
+
+	if len(srcSnapshot) > 0 {
+		snameT := strings.Split(srcSnapshot, "_")
+		if len(snameT) != 2 {
+			msg := "Unable to obtain volume name from snapshot name"
+			l.Warn(msg)
+			return status.Error(codes.NotFound, msg)
+		}
+		vname = snameT[0]
+		sname = srcSnapshot
+	} else {
+		vname = srcVolume
+		sname = copySnapshotName(srcVolume, nvID)
+
+		rErr := (*cp.endpoints[0]).CreateSnapshot(vname, sname)
+		if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rErr := (*cp.endpoints[0]).CopyVolume(vname, sname, nvID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestObjectExists:
+		case rest.RestResourceDNE:
+			return status.Error(codes.NotFound, rErr.Error())
+		default:
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	msg := fmt.Sprintf("Copy of %s@%s to volume %s started", vname, sname, nvID)
+	l.Trace(msg)
+	return status.Error(codes.Aborted, msg)
+}
+
+// completeVolumeCopy removes snapshots used for copy
+func (cp *ControllerPlugin) completeVolumeCopy(l *logrus.Entry,
+	req *csi.CreateVolumeRequest,
+	out *csi.CreateVolumeResponse,
+	nvID string,
+	c *rest.VolumeCopy) (*csi.CreateVolumeResponse, error) {
+
+	or, err := parseOrigin(c.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot is copied along with data
+	rErr := (*cp.endpoints[0]).DeleteSnapshot(nvID, or.Snapshot)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		l.Warnf("Unable to delete snapshot %s of %s: %s", or.Snapshot, nvID, rErr.Error())
+	}
+
+	if isCopySnapshot(or.Volume, or.Snapshot) {
+		rErr = (*cp.endpoints[0]).DeleteSnapshot(or.Volume, or.Snapshot)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			l.Warnf("Unable to delete snapshot %s: %s", or.Snapshot, rErr.Error())
+		}
+	}
+
+	vSize, err := cp.getVolumeSize(nvID)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Volume.VolumeId = nvID
+	out.Volume.CapacityBytes = vSize
+
+	if err = cp.applyVolumeQoS(nvID, req.GetParameters()); err != nil {
+		return nil, err
+	}
+	if err = cp.putVolumeRecord(nvID, req); err != nil {
+		return nil, err
+	}
+
+	l.Tracef("Copy of volume %s done", nvID)
+	return out, nil
+}
@@ -0,0 +1,289 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Group snapshot is a set of volume snapshots made in a single transaction.
+// Every member is named <volume>_<group id>, so it is a regular CSI snapshot
+// that can be used as a volume source and group members are found by name.
+
+// getGroupSnapshotID returns id of group snapshot with given name
+func (cp *ControllerPlugin) getGroupSnapshotID(name string) string {
+	// Prefix prevents collision with ids of regular snapshots
+	return cp.getStandardId(cp.cfg.Salt, "group:"+name)
+}
+
+// getGroupMembers lists member snapshots of group snapshot
+func (cp *ControllerPlugin) getGroupMembers(gID string) ([]rest.SnapshotShort, error) {
+	suffix := "_" + gID
+	filter := func(s string) bool {
+		if strings.HasPrefix(s, "c_") {
+			return false
+		}
+		snameT := strings.Split(s, "_")
+		return strings.HasSuffix(s, suffix) && len(snameT) == 2 && cp.ownsVolume(snameT[0])
+	}
+
+	snaps, rErr := (*cp.endpoints[0]).ListAllSnapshots(filter)
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+	return snaps, nil
+}
+
+// putGroupRecords makes records of group members that have none
+func (cp *ControllerPlugin) putGroupRecords(gID string, name string, members []rest.SnapshotShort) error {
+	owner := Ownership{
+		Instance: cp.instance,
+		Created:  time.Now().UTC(),
+	}
+	for _, m := range members {
+		vname := strings.TrimSuffix(m.Name, "_"+gID)
+
+		props, rErr := (*cp.endpoints[0]).GetSnapshotProperties(vname, m.Name)
+		if rErr != nil {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+		if len(props[propGroup]) > 0 {
+			continue
+		}
+
+		err := cp.meta.PutSnapshot(gID, SnapshotRecord{
+			ID:           m.Name,
+			Name:         name,
+			SourceVolume: vname,
+			Group:        gID,
+			Ownership:    owner,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeGroupMembers provides CSI description of member snapshots
+func (cp *ControllerPlugin) describeGroupMembers(gID string, members []rest.SnapshotShort) (*VolumeGroupSnapshot, error) {
+	out := &VolumeGroupSnapshot{
+		GroupSnapshotID: gID,
+		Snapshots:       make([]GroupMemberSnapshot, 0, len(members)),
+	}
+
+	for _, m := range members {
+		vname := strings.TrimSuffix(m.Name, "_"+gID)
+
+		s, err := cp.getSnapshot(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		cTime, rErr := rest.GetTimeStamp(s.Creation)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+
+		var size int64
+		if v, err := cp.getVolume(vname); err == nil {
+			size, _ = strconv.ParseInt(v.Volsize, 10, 64)
+		}
+
+		out.Snapshots = append(out.Snapshots, GroupMemberSnapshot{
+			SnapshotID:     m.Name,
+			SourceVolumeID: vname,
+			CreationTime:   cTime,
+			SizeBytes:      size,
+			ReadyToUse:     true,
+		})
+	}
+
+	sort.Slice(out.Snapshots, func(i, j int) bool {
+		return out.Snapshots[i].SourceVolumeID < out.Snapshots[j].SourceVolumeID
+	})
+	return out, nil
+}
+
+// CreateVolumeGroupSnapshot snapshots set of volumes at the same point in time
+func (cp *ControllerPlugin) CreateVolumeGroupSnapshot(ctx context.Context, req *CreateVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "CreateVolumeGroupSnapshot",
+	})
+
+	//////////////////////////////////////////////////////////////////////////////
+	/// Checks
+	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT) {
+		return nil, status.Errorf(codes.Internal, "Capability is not supported.")
+	}
+
+	if len(req.Name) == 0 {
+		msg := "Group snapshot name missing in request"
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if len(req.SourceVolumeIDs) == 0 {
+		msg := "Source volumes missing in request"
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	vnames := append([]string{}, req.SourceVolumeIDs...)
+	sort.Strings(vnames)
+	for i, vname := range vnames {
+		if !cp.ownsVolume(vname) || isNFSVolume(vname) {
+			msg := fmt.Sprintf("Volume id %s can not be a part of group snapshot", vname)
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		if i > 0 && vnames[i-1] == vname {
+			msg := fmt.Sprintf("Volume %s is listed several times", vname)
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+	}
+	//////////////////////////////////////////////////////////////////////////////
+
+	gID := cp.getGroupSnapshotID(req.Name)
+
+	// Volumes are locked in sorted order to avoid dead locks
+	for i, vname := range vnames {
+		if err := cp.lockVolume(vname); err != nil {
+			for _, locked := range vnames[:i] {
+				cp.unlockVolume(locked)
+			}
+			return nil, err
+		}
+	}
+	defer func() {
+		for _, vname := range vnames {
+			cp.unlockVolume(vname)
+		}
+	}()
+
+	members, err := cp.getGroupMembers(gID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) > 0 {
+		// Repeated request has to specify same set of volumes
+		existing := make([]string, 0, len(members))
+		for _, m := range members {
+			existing = append(existing, strings.TrimSuffix(m.Name, "_"+gID))
+		}
+		sort.Strings(existing)
+		if strings.Join(existing, ",") != strings.Join(vnames, ",") {
+			msg := fmt.Sprintf("Group snapshot %s exists with different volumes", req.Name)
+			l.Warn(msg)
+			return nil, status.Error(codes.AlreadyExists, msg)
+		}
+		// Previous request might have failed before records were stored
+		if err = cp.putGroupRecords(gID, req.Name, members); err != nil {
+			return nil, err
+		}
+		return cp.describeGroupMembers(gID, members)
+	}
+
+	snapshots := make([]rest.VolumeSnapshot, 0, len(vnames))
+	for _, vname := range vnames {
+		if _, err = cp.getVolume(vname); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, rest.VolumeSnapshot{
+			Volume:        vname,
+			Snapshot_name: fmt.Sprintf("%s_%s", vname, gID),
+		})
+	}
+
+	rErr := (*cp.endpoints[0]).CreateSnapshots(snapshots)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		case rest.RestResourceDNE:
+			return nil, status.Error(codes.NotFound, rErr.Error())
+		case rest.RestObjectExists:
+			return nil, status.Error(codes.AlreadyExists, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	if members, err = cp.getGroupMembers(gID); err != nil {
+		return nil, err
+	}
+	if err = cp.putGroupRecords(gID, req.Name, members); err != nil {
+		return nil, err
+	}
+	return cp.describeGroupMembers(gID, members)
+}
+
+// GetVolumeGroupSnapshot lists members of group snapshot
+func (cp *ControllerPlugin) GetVolumeGroupSnapshot(ctx context.Context, req *GetVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error) {
+	if len(req.GroupSnapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot id missing in request")
+	}
+
+	members, err := cp.getGroupMembers(req.GroupSnapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		msg := fmt.Sprintf("Group snapshot %s not found", req.GroupSnapshotID)
+		return nil, status.Error(codes.NotFound, msg)
+	}
+	return cp.describeGroupMembers(req.GroupSnapshotID, members)
+}
+
+// DeleteVolumeGroupSnapshot deletes all members of group snapshot
+//
+// Members that are sources of other volumes prevent group from being deleted
+func (cp *ControllerPlugin) DeleteVolumeGroupSnapshot(ctx context.Context, req *DeleteVolumeGroupSnapshotRequest) (*DeleteVolumeGroupSnapshotResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "DeleteVolumeGroupSnapshot",
+	})
+
+	if len(req.GroupSnapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot id missing in request")
+	}
+
+	members, err := cp.getGroupMembers(req.GroupSnapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check all members before deleting any of them
+	for _, m := range members {
+		s, err := cp.getSnapshot(m.Name)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, err
+		}
+		if len(s.Clones) > 0 {
+			msg := fmt.Sprintf("Snapshot %s is a parent of %s", m.Name, s.Clones)
+			l.Warn(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+	}
+
+	for _, m := range members {
+		dReq := &csi.DeleteSnapshotRequest{SnapshotId: m.Name}
+		if _, err = cp.DeleteSnapshot(ctx, dReq); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DeleteVolumeGroupSnapshotResponse{}, nil
+}
@@ -0,0 +1,59 @@
+package joviandss
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// objectMetadata converts ownership to extension service representation
+func objectMetadata(id string, name string, source string, o Ownership) ObjectMetadata {
+	out := ObjectMetadata{
+		ID:             id,
+		Name:           name,
+		SourceVolumeID: source,
+		Owner:          o.Owner,
+		Namespace:      o.Namespace,
+		Content:        o.Content,
+		Instance:       o.Instance,
+	}
+	if !o.Created.IsZero() {
+		out.CreationTime = o.Created.Unix()
+	}
+	return out
+}
+
+// LookupObjects finds volumes and snapshots with metadata property equal to value
+func (cp *ControllerPlugin) LookupObjects(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "LookupObjects",
+	})
+
+	if len(req.Key) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Lookup key missing in request")
+	}
+	l.Tracef("Lookup objects with %s=%s", req.Key, req.Value)
+
+	vRecs, err := cp.meta.FindVolumes(req.Key, req.Value)
+	if err != nil {
+		return nil, err
+	}
+	sRecs, err := cp.meta.FindSnapshots(req.Key, req.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &LookupResponse{
+		Volumes:   make([]ObjectMetadata, 0, len(vRecs)),
+		Snapshots: make([]ObjectMetadata, 0, len(sRecs)),
+	}
+	for _, v := range vRecs {
+		out.Volumes = append(out.Volumes, objectMetadata(v.ID, v.Name, "", v.Ownership))
+	}
+	for _, s := range sRecs {
+		out.Snapshots = append(out.Snapshots, objectMetadata(s.ID, s.Name, s.SourceVolume, s.Ownership))
+	}
+	return out, nil
+}
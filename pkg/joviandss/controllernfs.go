@@ -0,0 +1,277 @@
+package joviandss
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ProtocolNFS storage class protocol for volumes shared over NFS
+	ProtocolNFS = "nfs"
+
+	nfsVolumePrefix = "nfs-"
+	nfsExportPrefix = "/Pools/"
+)
+
+// isNFSVolume checks if volume id belongs to NFS shared dataset
+func isNFSVolume(vID string) bool {
+	return strings.HasPrefix(vID, nfsVolumePrefix)
+}
+
+// isProtocolSupported checks protocol name provided in storage class
+func isProtocolSupported(name string) bool {
+	switch name {
+	case "", ProtocolNFS:
+		return true
+	}
+	return false
+}
+
+// parseNodeID splits node id into node name and address node uses for NFS,
+// address was a part of node id reported by older nodes
+//
+// Node id has form name@address if node has NFS address configured
+func parseNodeID(nID string) (name string, addr string) {
+	i := strings.LastIndex(nID, "@")
+	if i < 0 {
+		return nID, ""
+	}
+	return nID[:i], nID[i+1:]
+}
+
+// nodeNFSAddr returns address node accesses NFS shares from
+func (cp *ControllerPlugin) nodeNFSAddr(nID string) string {
+	name, addr := parseNodeID(nID)
+	if len(addr) > 0 {
+		return addr
+	}
+	return cp.cfg.NfsNodes[name]
+}
+
+// getNFSVolume provides information about dataset of the volume
+func (cp *ControllerPlugin) getNFSVolume(vID string) (*rest.Dataset, error) {
+	d, rErr := (*cp.endpoints[0]).GetDataset(vID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return nil, status.Error(codes.NotFound, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+	return d, nil
+}
+
+// createNFSVolume creates dataset limited by quota and shares it over NFS
+func (cp *ControllerPlugin) createNFSVolume(l *logrus.Entry, req *csi.CreateVolumeRequest,
+	volumeSize int64) (*csi.CreateVolumeResponse, error) {
+
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"NFS volumes can not be created from other sources")
+	}
+
+	volumeID := nfsVolumePrefix + cp.volumeID(req.GetName())
+
+	out := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: volumeSize,
+			VolumeContext: req.GetParameters(),
+		},
+	}
+
+	d, err := cp.getNFSVolume(volumeID)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+
+	if d != nil {
+		if d.Quota != volumeSize {
+			msg := fmt.Sprintf("Exists volume with size %d, when requsted for %d", d.Quota, volumeSize)
+			l.Warn(msg)
+			return nil, status.Error(codes.AlreadyExists, msg)
+		}
+		l.Tracef("Request for the same volume %s with size %d ", volumeID, volumeSize)
+	} else {
+		rErr := (*cp.endpoints[0]).CreateDataset(volumeID, volumeSize)
+		if rErr != nil {
+			switch rErr.GetCode() {
+			case rest.RestResourceBusy:
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestObjectExists:
+				l.Warn("Specified volume already exists.")
+			default:
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+	}
+
+	// Share might be missing if previous attempt failed half way
+	rErr := (*cp.endpoints[0]).CreateNFSShare(volumeID, volumeID)
+	if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	return out, nil
+}
+
+// deleteNFSVolume removes NFS share and dataset of the volume
+func (cp *ControllerPlugin) deleteNFSVolume(l *logrus.Entry, vID string) (*csi.DeleteVolumeResponse, error) {
+
+	if err := cp.lockVolume(vID); err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	defer cp.unlockVolume(vID)
+
+	rErr := (*cp.endpoints[0]).DeleteNFSShare(vID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rErr = (*cp.endpoints[0]).DeleteDataset(vID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	l.Tracef("NFS volume %s deleted", vID)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// removeAddr returns list without given address
+func removeAddr(list []string, addr string) []string {
+	out := make([]string, 0, len(list))
+	for _, a := range list {
+		if a != addr {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// publishNFS grants node access to NFS share of the volume
+func (cp *ControllerPlugin) publishNFS(l *logrus.Entry, vname string, nID string,
+	caps *csi.VolumeCapability, roMode bool) (*csi.ControllerPublishVolumeResponse, error) {
+
+	addr := cp.nodeNFSAddr(nID)
+	if len(addr) == 0 {
+		msg := fmt.Sprintf("NFS address of node %s is not configured", nID)
+		l.Warn(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+
+	// Share access list is shared among nodes
+	if err := cp.lockVolume(vname); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vname)
+
+	share, rErr := (*cp.endpoints[0]).GetNFSShare(vname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return nil, status.Error(codes.NotFound, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rw := removeAddr(share.NFS.AllowWriteIP, addr)
+	ro := make([]string, 0)
+	for _, a := range share.NFS.AllowAccessIP {
+		if a != addr && len(removeAddr(rw, a)) == len(rw) {
+			ro = append(ro, a)
+		}
+	}
+
+	if (len(rw) > 0 || len(ro) > 0) && isMultiNodeMode(caps.GetAccessMode().GetMode()) == false {
+		msg := fmt.Sprintf("Volume %s is published to other node", vname)
+		l.Warn(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+
+	if roMode {
+		ro = append(ro, addr)
+	} else {
+		rw = append(rw, addr)
+	}
+
+	rErr = (*cp.endpoints[0]).SetNFSShareAccess(vname, rw, ro)
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	sAddr, _ := (*cp.endpoints[0]).GetAddress()
+	pCtx := map[string]string{
+		"protocol": ProtocolNFS,
+		"addr":     sAddr,
+		"export":   nfsExportPrefix + share.Path,
+	}
+	if roMode {
+		pCtx["readonly"] = "true"
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: pCtx,
+	}, nil
+}
+
+// unpublishNFS revokes node access to NFS share of the volume
+//
+// Volume has to be locked by caller
+func (cp *ControllerPlugin) unpublishNFS(l *logrus.Entry, vname string, nID string) (*csi.ControllerUnpublishVolumeResponse, error) {
+
+	share, rErr := (*cp.endpoints[0]).GetNFSShare(vname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	// Empty node id means volume should be unpublished from all nodes
+	rw := []string{}
+	ro := []string{}
+	if len(nID) > 0 {
+		addr := cp.nodeNFSAddr(nID)
+		if len(addr) == 0 {
+			msg := fmt.Sprintf("NFS address of node %s is not configured", nID)
+			l.Warn(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+		rw = removeAddr(share.NFS.AllowWriteIP, addr)
+		for _, a := range removeAddr(share.NFS.AllowAccessIP, addr) {
+			if len(removeAddr(rw, a)) == len(rw) {
+				ro = append(ro, a)
+			}
+		}
+	}
+
+	rErr = (*cp.endpoints[0]).SetNFSShareAccess(vname, rw, ro)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	l.Tracef("Volume %s is still published to %d nodes", vname, len(rw)+len(ro))
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
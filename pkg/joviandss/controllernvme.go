@@ -0,0 +1,219 @@
+package joviandss
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nvmeNsid namespace id of the volume, subsystem exports single volume
+const nvmeNsid = 1
+
+// getHostNqn returns qualified name node uses to connect to subsystems
+//
+// Controller do not know real host NQN of the node, so it is derived from node id
+func (cp *ControllerPlugin) getHostNqn(nID string) string {
+	return fmt.Sprintf("%s:host:%s", cp.nqn, strings.ToLower(nID))
+}
+
+// publishNVMe exports volume over NVMe/TCP to the node
+func (cp *ControllerPlugin) publishNVMe(l *logrus.Entry, vname string, nID string,
+	caps *csi.VolumeCapability, roMode bool) (*csi.ControllerPublishVolumeResponse, error) {
+
+	var err error
+
+	// Subsystem is shared among nodes, protect it from concurrent modifications
+	if err = cp.lockVolume(vname); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vname)
+
+	zvol := storageVolume(vname)
+	if _, err = cp.getVolume(zvol); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if !isStaticVolume(vname) {
+		if err = cp.checkNotFlattening(vname); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+	}
+
+	sname := fmt.Sprintf("%s:%s", cp.nqn, vname)
+	hnqn := cp.getHostNqn(nID)
+
+	sExists := true
+	_, rErr := (*cp.endpoints[0]).GetNVMeSubsystem(sname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			sExists = false
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	hExists := false
+	if sExists {
+		var hosts []rest.NVMeHost
+		hosts, rErr = (*cp.endpoints[0]).GetNVMeHosts(sname)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		for _, h := range hosts {
+			if h.Nqn == hnqn {
+				hExists = true
+				continue
+			}
+			if isMultiNodeMode(caps.GetAccessMode().GetMode()) == false {
+				msg := fmt.Sprintf("Volume %s is published to other node", vname)
+				l.Warn(msg)
+				return nil, status.Error(codes.FailedPrecondition, msg)
+			}
+		}
+	} else {
+		rErr = (*cp.endpoints[0]).CreateNVMeSubsystem(sname)
+		if rErr != nil {
+			switch rErr.GetCode() {
+			case rest.RestResourceBusy:
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestObjectExists:
+				return nil, status.Error(codes.AlreadyExists, rErr.Error())
+			default:
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+	}
+
+	if hExists == false {
+		rErr = (*cp.endpoints[0]).AddNVMeHost(sname, hnqn)
+		if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	var mode string
+	if roMode == true {
+		mode = "ro"
+	} else {
+		mode = "wt"
+	}
+
+	nsAttached := false
+	if sExists {
+		var nss []rest.NVMeNamespace
+		nss, rErr = (*cp.endpoints[0]).GetNVMeNamespaces(sname)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		for _, ns := range nss {
+			if ns.Name != zvol {
+				continue
+			}
+			// Namespace mode is shared by all nodes using subsystem
+			if ns.Mode != mode {
+				msg := fmt.Sprintf("Volume %s is already published in %s mode", vname, ns.Mode)
+				l.Warn(msg)
+				return nil, status.Error(codes.AlreadyExists, msg)
+			}
+			nsAttached = true
+		}
+	}
+
+	if nsAttached == false {
+		rErr = (*cp.endpoints[0]).AddNVMeNamespace(sname, zvol, nvmeNsid, mode)
+		if rErr != nil {
+			switch rErr.GetCode() {
+			case rest.RestResourceBusy:
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			default:
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+	}
+
+	pCtx := map[string]string{
+		"transport": TransportNVMeTCP,
+		"nqn":       strings.ToLower(sname),
+		"nsid":      strconv.Itoa(nvmeNsid),
+		"hostnqn":   hnqn,
+	}
+	if roMode {
+		pCtx["readonly"] = "true"
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: pCtx,
+	}, nil
+}
+
+// unpublishNVMe removes node from subsystem hosts,
+// subsystem is deleted once no nodes are using it
+//
+// Volume has to be locked by caller
+func (cp *ControllerPlugin) unpublishNVMe(l *logrus.Entry, vname string, nID string) (*csi.ControllerUnpublishVolumeResponse, error) {
+
+	sname := fmt.Sprintf("%s:%s", cp.nqn, vname)
+
+	hosts, rErr := (*cp.endpoints[0]).GetNVMeHosts(sname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	// Empty node id means volume should be unpublished from all nodes
+	if len(nID) > 0 {
+		hnqn := cp.getHostNqn(nID)
+		remaining := 0
+		for _, h := range hosts {
+			if h.Nqn != hnqn {
+				remaining++
+				continue
+			}
+			rErr = (*cp.endpoints[0]).DeleteNVMeHost(sname, hnqn)
+			if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+
+		if remaining > 0 {
+			l.Tracef("Volume %s is still published to %d nodes", vname, remaining)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+	}
+
+	rErr = (*cp.endpoints[0]).DeleteNVMeNamespace(sname, nvmeNsid)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rErr = (*cp.endpoints[0]).DeleteNVMeSubsystem(sname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
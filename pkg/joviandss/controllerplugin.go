@@ -33,6 +33,8 @@ const (
 
 const (
 	minVolumeSize = 16 * mib
+
+	targetActivationTimeout = 30 * time.Second
 )
 
 var supportedControllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
@@ -44,19 +46,17 @@ var supportedControllerCapabilities = []csi.ControllerServiceCapability_RPC_Type
 	csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 	csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
 	csi.ControllerServiceCapability_RPC_GET_CAPACITY,
-
-	//TODO:
-	//csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
+	csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
 }
 
 var supportedVolumeCapabilities = []csi.VolumeCapability_AccessMode_Mode{
 	//VolumeCapability_AccessMode_UNKNOWN,
 	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
-	//VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	//VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
-	//VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-
+	// Supported for block volumes only
+	csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
 }
 
 // ControllerPlugin provides CSI controller plugin interface
@@ -64,11 +64,21 @@ type ControllerPlugin struct {
 	l                *logrus.Entry
 	cfg              *ControllerCfg
 	iqn              string
-	snapReg          string
+	nqn              string
+	instance         string
+	meta             MetadataStore
+	ops              *operationTracker
 	volumesAccess    sync.Mutex
 	volumesInProcess map[string]bool
 
+	paramsAccess    sync.Mutex
+	params          map[string]map[string]string // cached parameters of volumes
+	policiesOnce    sync.Once
+	replicationOnce sync.Once
+
 	endpoints    []*rest.StorageInterface
+	replica      *rest.StorageInterface // secondary storage volumes are replicated to
+	kms          KMS
 	capabilities []*csi.ControllerServiceCapability
 	vCap         []*csi.VolumeCapability
 }
@@ -102,6 +112,28 @@ func parseOrigin(or string) (*origin, error) {
 
 // GetControllerPlugin get plugin information
 func GetControllerPlugin(cfg *ControllerCfg, l *logrus.Entry) (
+	cp *ControllerPlugin,
+	err error) {
+	if cp, err = newControllerPlugin(cfg, l); err != nil {
+		return nil, err
+	}
+
+	// Older versions kept snapshot records on a dedicated volume,
+	// they had no instances, so register belongs to default one
+	if len(cp.instance) == 0 {
+		register := cp.cfg.Nodeprefix + legacySnapshotRegister
+		if err = migrateSnapshotRegister(cp.l, cp.endpoints[0], cp.meta, register); err != nil {
+			cp.l.Warnf("Unable to migrate snapshot register %s: %s", register, err)
+		}
+	}
+
+	go cp.startBackgroundTasks()
+
+	return cp, nil
+}
+
+// newControllerPlugin creates plugin without modifying storage
+func newControllerPlugin(cfg *ControllerCfg, l *logrus.Entry) (
 	cp *ControllerPlugin,
 	err error) {
 	cp = &ControllerPlugin{}
@@ -112,21 +144,35 @@ func GetControllerPlugin(cfg *ControllerCfg, l *logrus.Entry) (
 
 	cp.l = l.WithFields(lFields)
 
+	if err = validateInstance(cfg.Instance); err != nil {
+		cp.l.Warn(err.Error())
+		return nil, err
+	}
+	cp.instance = cfg.Instance
+
 	if len(cfg.Iqn) == 0 {
 		cfg.Iqn = "iqn.csi.2019-04"
 	}
-	cp.iqn = cfg.Iqn
+	cp.iqn = instanceIqn(cfg.Iqn, cp.instance)
+	if len(cfg.Nqn) == 0 {
+		cfg.Nqn = "nqn.2019-04.csi.joviandss"
+	}
+	cp.nqn = instanceIqn(cfg.Nqn, cp.instance)
 	cp.cfg = cfg
 
 	cp.volumesInProcess = make(map[string]bool)
+	cp.params = make(map[string]map[string]string)
+	cp.ops = newOperationTracker(cp.l)
 
 	// Init Storage endpoints
 	for _, sConfig := range cfg.StorageEndpoints {
 		var storage rest.StorageInterface
 		storage, err = rest.NewProvider(&sConfig, l)
 		if err != nil {
+			sc := sConfig
+			sc.Pass = strippedValue
 			cp.l.Warnf("Creating Storage Endpoint failure %+v. Error %s",
-				sConfig,
+				sc,
 				err)
 			continue
 		}
@@ -141,35 +187,27 @@ func GetControllerPlugin(cfg *ControllerCfg, l *logrus.Entry) (
 
 	cp.vCap = GetVolumeCapability(supportedVolumeCapabilities)
 
-	// Init tmp volume
-	cp.snapReg = cp.cfg.Nodeprefix + "SnapshotRegister"
-	_, err = cp.getVolume(cp.snapReg)
-	if err == nil {
-		return cp, nil
-	}
-	vd := rest.CreateVolumeDescriptor{
-		Name: cp.snapReg,
-		Size: minVolumeSize,
-	}
-	rErr := (*cp.endpoints[0]).CreateVolume(vd)
+	cp.meta = newPropertyStore(cp.l, cp.endpoints[0], cp.ownsVolume)
 
-	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err = status.Errorf(codes.Internal, rErr.Error())
-			return nil, err
+	if _, err = parseSnapshotPolicy(cfg.SnapshotPolicy); err != nil {
+		cp.l.Warn(err.Error())
+		return nil, err
+	}
 
-		case rest.RestObjectExists:
-			cp.l.Warn("Snapshot register already exists.")
+	if cp.kms, err = newKMS(cfg.KMS); err != nil {
+		cp.l.Warnf("Unable to create KMS: %s", err)
+		return nil, err
+	}
 
-		default:
-			err = status.Errorf(codes.Internal, "Unknown internal error")
+	if len(cfg.Replica.Addr) > 0 {
+		var replica rest.StorageInterface
+		if replica, err = rest.NewProvider(&cfg.Replica, l); err != nil {
+			cp.l.Warnf("Creating secondary storage endpoint failure %s. Error %s",
+				cfg.Replica.Name, err)
 			return nil, err
 		}
+		cp.replica = &replica
+		cp.l.Tracef("Add secondary endpoint %s", cfg.Replica.Name)
 	}
 
 	return cp, nil
@@ -399,7 +437,7 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 	/// Checks
 	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME) {
 		err = status.Errorf(codes.Internal, "Capability is not supported.")
-		l.Warnf("Unable to create volume req: %v", req)
+		l.Warnf("Unable to create volume req: %s", stripSecrets(req))
 		return nil, err
 	}
 	vName := req.GetName()
@@ -408,12 +446,70 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Error(codes.InvalidArgument, "Name missing in request")
 	}
 
-	//TODO: process volume capabilities
 	caps := req.GetVolumeCapabilities()
 	if caps == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
 
+	if tr := req.GetParameters()["transport"]; !isTransportSupported(tr) {
+		msg := fmt.Sprintf("Transport %s is not supported", tr)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	protocol := req.GetParameters()["protocol"]
+	if !isProtocolSupported(protocol) {
+		msg := fmt.Sprintf("Protocol %s is not supported", protocol)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	cloneMode := req.GetParameters()["cloneMode"]
+	if !isCloneModeSupported(cloneMode) {
+		msg := fmt.Sprintf("Clone mode %s is not supported", cloneMode)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if _, err = parseSnapshotPolicy(req.GetParameters()[paramSnapshotPolicy]); err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if interval, err := parseReplicationInterval(req.GetParameters()[paramReplicationInterval]); err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if interval > 0 && (cp.replica == nil || protocol == ProtocolNFS) {
+		msg := "Replication requires secondary storage and is not supported for NFS volumes"
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	encryption := req.GetParameters()[paramEncryption]
+	if !isEncryptionSupported(encryption) {
+		msg := fmt.Sprintf("Encryption %s is not supported", encryption)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	if encryption == EncryptionKMS && cp.kms == nil {
+		msg := "Encryption kms requires KMS in controller config"
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	_, qosSet, err := parseVolumeQoS(req.GetParameters())
+	if err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for _, c := range caps {
+		if err = validateVolumeCapability(c, protocol == ProtocolNFS); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+	}
+
 	volumeSize := req.GetCapacityRange().GetRequiredBytes()
 
 	if volumeSize < minVolumeSize {
@@ -433,10 +529,29 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		vName,
 		volumeSize)
 
+	if protocol == ProtocolNFS {
+		if cloneMode == CloneModeFull {
+			msg := "Full copy is not supported for NFS volumes"
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		if len(encryption) > 0 {
+			msg := "Encryption is not supported for NFS volumes"
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		if qosSet {
+			msg := "IO limits are not supported for NFS volumes"
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		return cp.createNFSVolume(l, req, volumeSize)
+	}
+
 	//////////////////////////////////////////////////////////////////////////////
 	// Check if volume exists
 
-	volumeID := cp.getStandardId(cp.cfg.Salt, vName)
+	volumeID := cp.volumeID(vName)
 
 	v, err := cp.getVolume(volumeID)
 
@@ -457,6 +572,11 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		if srcSnapshot := vSource.GetSnapshot(); srcSnapshot != nil {
 			// Snapshot
 			sourceSnapshot = srcSnapshot.GetSnapshotId()
+			if !cp.ownsSnapshot(sourceSnapshot) {
+				msg := fmt.Sprintf("Snapshot %s belongs to other instance", sourceSnapshot)
+				l.Warn(msg)
+				return nil, status.Error(codes.NotFound, msg)
+			}
 			// Check if snapshot exists
 			if _, err = cp.getSnapshot(sourceSnapshot); err != nil {
 				return nil, err
@@ -466,6 +586,11 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		} else if srcVolume := vSource.GetVolume(); srcVolume != nil {
 			// Volume
 			sourceVolume = srcVolume.GetVolumeId()
+			if !cp.ownsVolume(sourceVolume) || isNFSVolume(sourceVolume) {
+				msg := fmt.Sprintf("Volume %s belongs to other instance", sourceVolume)
+				l.Warn(msg)
+				return nil, status.Error(codes.NotFound, msg)
+			}
 			// Check if volume exists
 			if _, err = cp.getVolume(sourceVolume); err != nil {
 				return nil, err
@@ -477,6 +602,26 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
+	// Clones share key of their source
+	keyRef, err := cp.encryptionKeyRef(volumeID, req)
+	if err != nil {
+		return nil, err
+	}
+	if vSource != nil && len(encryption) > 0 && len(keyRef) == 0 {
+		msg := "Volume made of not encrypted source can not be encrypted"
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if cloneMode == CloneModeFull && vSource != nil {
+		if len(keyRef) > 0 {
+			msg := "Full copy of encrypted volume is not supported"
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		return cp.createVolumeCopy(l, req, &out, volumeID, sourceVolume, sourceSnapshot)
+	}
+
 	// TODO: develop support for different max capacity
 	// if voluem exists make shure it has same size
 	if v != nil {
@@ -491,6 +636,19 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		// Volume exists
 		l.Tracef("Request for the same volume %s with size %d ", volumeID, vSize)
 
+		// Previous request might have failed before limits were set,
+		// volume with record might have limits modified since then
+		if rec, err := cp.meta.GetVolume(volumeID); err != nil {
+			return nil, err
+		} else if rec == nil {
+			if err = cp.applyVolumeQoS(volumeID, req.GetParameters()); err != nil {
+				return nil, err
+			}
+			if err = cp.putVolumeRecord(volumeID, req); err != nil {
+				return nil, err
+			}
+		}
+
 		out.Volume.VolumeId = volumeID
 		out.Volume.CapacityBytes = volumeSize
 
@@ -498,7 +656,7 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	}
 	//////////////////////////////////////////////////////////////////////////////
-	l.Tracef("req: %+v ", req)
+	l.Tracef("req: %s ", stripSecrets(req))
 
 	// Create volume
 
@@ -506,6 +664,16 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		Name: volumeID,
 		Size: volumeSize,
 	}
+	if vSource == nil && len(encryption) > 0 {
+		if err = cp.checkPoolEncryption(); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+		if vd.Key, err = cp.createVolumeKey(encryption, volumeID, req.GetSecrets()); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+	}
 	var rErr rest.RestError
 
 	if len(sourceSnapshot) > 0 {
@@ -522,6 +690,13 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		out.Volume.VolumeId = volumeID
 		out.Volume.CapacityBytes = vSize
 
+		if err = cp.applyVolumeQoS(volumeID, req.GetParameters()); err != nil {
+			return nil, err
+		}
+		if err = cp.putVolumeRecord(volumeID, req); err != nil {
+			return nil, err
+		}
+
 		return &out, nil
 
 	} else if len(sourceVolume) > 0 {
@@ -538,6 +713,13 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 		out.Volume.VolumeId = volumeID
 		out.Volume.CapacityBytes = vSize
 
+		if err = cp.applyVolumeQoS(volumeID, req.GetParameters()); err != nil {
+			return nil, err
+		}
+		if err = cp.putVolumeRecord(volumeID, req); err != nil {
+			return nil, err
+		}
+
 		return &out, nil
 
 	} else {
@@ -565,10 +747,43 @@ func (cp *ControllerPlugin) CreateVolume(ctx context.Context, req *csi.CreateVol
 	out.Volume.VolumeId = volumeID
 	out.Volume.CapacityBytes = volumeSize
 
+	if err = cp.applyVolumeQoS(volumeID, req.GetParameters()); err != nil {
+		return nil, err
+	}
+	if err = cp.putVolumeRecord(volumeID, req); err != nil {
+		return nil, err
+	}
+
 	return &out, nil
 
 }
 
+// putVolumeRecord stores CSI name, parameters and ownership of created volume
+//
+// Record keeps reference to key of encrypted volume, so volume
+// is reported as created only once record is stored
+func (cp *ControllerPlugin) putVolumeRecord(vID string, req *csi.CreateVolumeRequest) error {
+	keyRef, err := cp.encryptionKeyRef(vID, req)
+	if err != nil {
+		return err
+	}
+	params := storageParameters(req.GetParameters())
+	err = cp.meta.PutVolume(VolumeRecord{
+		ID:         vID,
+		Name:       req.GetName(),
+		Parameters: params,
+		Ownership:  volumeOwnership(req.GetParameters(), cp.instance),
+
+		EncryptionKey: keyRef,
+	})
+	if err != nil {
+		return err
+	}
+	cp.cacheVolumeParameters(vID, params)
+	cp.startVolumeTasks(params)
+	return nil
+}
+
 // getVolumeSnapshots return array of public volume snapshots
 func (cp *ControllerPlugin) getVolumeSnapshots(vname string) ([]rest.SnapshotShort, error) {
 	filter := func(s string) bool {
@@ -646,6 +861,11 @@ func (cp *ControllerPlugin) getVolumeAllSnapshots(vname string) ([]rest.Snapshot
 }
 
 func (cp *ControllerPlugin) gcVolume(vname string) error {
+	if !cp.ownsVolume(vname) {
+		cp.l.Warnf("Volume %s belongs to other instance, skip GC", vname)
+		return nil
+	}
+
 	if err := cp.lockVolume(vname); err != nil {
 		return err
 	}
@@ -774,17 +994,62 @@ func (cp *ControllerPlugin) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME) {
 		err = status.Errorf(codes.Internal, "Capability is not supported.")
-		cp.l.Warnf("Unable to delete volume req: %v", req)
+		cp.l.Warnf("Unable to delete volume req: %s", stripSecrets(req))
 		return nil, err
 	}
 
 	vID := req.VolumeId
 	l.Tracef("Deleting volume %s", vID)
 
+	if isStaticVolume(vID) {
+		return cp.deleteStaticVolume(l, vID)
+	}
+
+	if !cp.ownsVolume(vID) {
+		l.Warnf("Volume %s belongs to other instance, skip deletion", vID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if isNFSVolume(vID) {
+		return cp.deleteNFSVolume(l, vID)
+	}
+
+	// Deletion of volume with a lot of data might take a while
+	op := cp.ops.run(ctx, "DeleteVolume/"+vID, func(op *operation) error {
+		_, err := cp.deleteVolume(l, vID)
+		return err
+	})
+	if !op.Done() {
+		msg := fmt.Sprintf("Deletion of volume %s is in progress", vID)
+		l.Trace(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	if err = op.Err(); err != nil {
+		return nil, err
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// deleteVolume deletes volume or conceals it if it has clones
+func (cp *ControllerPlugin) deleteVolume(l *logrus.Entry, vID string) (*csi.DeleteVolumeResponse, error) {
+	var err error
+
 	// Protect volume from modifications
 	if err = cp.lockVolume(vID); err != nil {
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
+	cp.cacheVolumeParameters(vID, nil)
+
+	// Volume is replaced with its copy once flatten is done
+	if inProgress, err := cp.flattenInProgress(vID); err != nil || inProgress {
+		cp.unlockVolume(vID)
+		if err != nil {
+			return nil, err
+		}
+		msg := fmt.Sprintf("Volume %s is being flattened", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
 
 	dvol, lErr := cp.getVolume(vID)
 	if codes.NotFound == status.Code(lErr) {
@@ -797,6 +1062,16 @@ func (cp *ControllerPlugin) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 		return nil, status.Errorf(codes.Internal, lErr.Error())
 	}
 
+	// Snapshots made by policy and replication are deleted along with volume
+	if lErr = cp.deletePolicySnapshots(vID); lErr != nil {
+		cp.unlockVolume(vID)
+		return nil, lErr
+	}
+	if lErr = cp.deleteReplicationSnapshots(vID); lErr != nil {
+		cp.unlockVolume(vID)
+		return nil, lErr
+	}
+
 	// Try to delete without recursiuon
 	lErr = (*cp.endpoints[0]).DeleteVolume(vID, false)
 
@@ -896,7 +1171,7 @@ func (cp *ControllerPlugin) ListVolumes(ctx context.Context, req *csi.ListVolume
 
 	//////////////////////////////////////////////////////////////////////////////
 
-	volumes, err := (*cp.endpoints[0]).ListVolumes()
+	allVolumes, err := (*cp.endpoints[0]).ListVolumes()
 
 	if err != nil {
 		switch err.GetCode() {
@@ -908,6 +1183,13 @@ func (cp *ControllerPlugin) ListVolumes(ctx context.Context, req *csi.ListVolume
 		}
 	}
 
+	volumes := make([]string, 0, len(allVolumes))
+	for _, name := range allVolumes {
+		if cp.ownsVolume(name) {
+			volumes = append(volumes, name)
+		}
+	}
+
 	// Just return all
 	if maxEnt == 0 {
 		entries := make([]*csi.ListVolumesResponse_Entry, len(volumes))
@@ -956,69 +1238,6 @@ func (cp *ControllerPlugin) ListVolumes(ctx context.Context, req *csi.ListVolume
 
 }
 
-func (cp *ControllerPlugin) putSnapshotRecord(sID string) error {
-	rErr := (*cp.endpoints[0]).CreateSnapshot(cp.snapReg, sID)
-
-	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err := status.Errorf(codes.Internal, rErr.Error())
-			return err
-
-		case rest.RestObjectExists:
-			cp.l.Warn("Specified snapshot record already exists.")
-			return nil
-
-		default:
-			err := status.Errorf(codes.Internal, "Unknown internal error")
-			return err
-		}
-	}
-	return nil
-}
-
-func (cp *ControllerPlugin) getSnapshotRecordExists(sID string) bool {
-	_, rErr := (*cp.endpoints[0]).GetSnapshot(cp.snapReg, sID)
-
-	if rErr != nil {
-		return false
-		cp.l.Infof("Snapshot record %s DNE", sID)
-	}
-	cp.l.Infof("Specified snapshot %s exists.", sID)
-	return true
-}
-
-func (cp *ControllerPlugin) delSnapshotRecord(sID string) error {
-
-	rErr := (*cp.endpoints[0]).DeleteSnapshot(cp.snapReg, sID)
-
-	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err := status.Errorf(codes.Internal, rErr.Error())
-			return err
-		case rest.RestObjectExists:
-			err := status.Errorf(codes.AlreadyExists, rErr.Error())
-			return err
-		case rest.RestResourceDNE:
-			return nil
-		default:
-			err := status.Errorf(codes.Internal, "Unknown internal error")
-			return err
-		}
-	}
-
-	return nil
-}
-
 // getSnapshot return snapshot datastructure
 func (cp *ControllerPlugin) getSnapshot(sID string) (*rest.Snapshot, error) {
 	l := cp.l.WithFields(logrus.Fields{
@@ -1114,7 +1333,7 @@ func (cp *ControllerPlugin) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT) {
 		err = status.Errorf(codes.Internal, "Capability is not supported.")
-		l.Warnf("Unable to create volume req: %v", req)
+		l.Warnf("Unable to create volume req: %s", stripSecrets(req))
 		return nil, err
 	}
 
@@ -1124,6 +1343,16 @@ func (cp *ControllerPlugin) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		l.Warn(msg)
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
+	if isStaticVolume(vname) {
+		msg := fmt.Sprintf("Snapshots of static volume %s are not supported", vname)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	if !cp.ownsVolume(vname) || isNFSVolume(vname) {
+		msg := fmt.Sprintf("Volume %s belongs to other instance", vname)
+		l.Warn(msg)
+		return nil, status.Error(codes.NotFound, msg)
+	}
 	sNameRaw := req.GetName()
 	// Get universal volume ID
 
@@ -1139,22 +1368,21 @@ func (cp *ControllerPlugin) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	sname := fmt.Sprintf("%s_%s", vname, sID)
 
-	bExists := cp.getSnapshotRecordExists(sID)
+	rec, err := cp.meta.GetSnapshot(sID)
+	if err != nil {
+		return nil, err
+	}
 
-	if bExists == true {
-		cp.l.Debugf("Snapshot record exists!")
-		var lerr error
-		if _, lerr = cp.getSnapshot(sname); codes.NotFound == grpc.Code(lerr) {
-			return nil, status.Error(codes.AlreadyExists, "Exists.")
-		}
-		if lerr != nil {
-			cp.l.Debugf("Err value of checking related property! %s", lerr.Error())
-		}
+	// Snapshot names are unique, not only among snapshots of a volume
+	if rec != nil && rec.SourceVolume != vname {
+		msg := fmt.Sprintf("Snapshot %s exists for volume %s", sNameRaw, rec.SourceVolume)
+		l.Warn(msg)
+		return nil, status.Error(codes.AlreadyExists, msg)
 	}
 
 	// Check if volume exists
 	//TODO: implement check if snapshot exists
-	l.Debugf("Req: %+v ", req)
+	l.Debugf("Req: %s ", stripSecrets(req))
 
 	// Get size of volume
 	var v *rest.Volume
@@ -1172,6 +1400,7 @@ func (cp *ControllerPlugin) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	}
 
+	// Snapshot is taken at once, so it is created synchronously
 	rErr := (*cp.endpoints[0]).CreateSnapshot(vname, sname)
 
 	if rErr != nil {
@@ -1193,7 +1422,15 @@ func (cp *ControllerPlugin) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		}
 	}
 	//Make record of created snapshot
-	cp.putSnapshotRecord(sID)
+	err = cp.meta.PutSnapshot(sID, SnapshotRecord{
+		ID:           sname,
+		Name:         sNameRaw,
+		SourceVolume: vname,
+		Ownership:    snapshotOwnership(req.GetParameters(), cp.instance),
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	var s *rest.Snapshot // s for snapshot
 	s, rErr = (*cp.endpoints[0]).GetSnapshot(vname, sname)
@@ -1249,14 +1486,14 @@ func (cp *ControllerPlugin) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 		"func": "DeleteSnapshot",
 	})
 
-	l.Tracef("Delete Snapshot req: %+v", req)
+	l.Tracef("Delete Snapshot req: %s", stripSecrets(req))
 	var err error
 
 	//////////////////////////////////////////////////////////////////////////////
 	/// Checks
 	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT) {
 		err = status.Errorf(codes.Internal, "Capability is not supported.")
-		l.Warnf("Unable to create volume req: %v", req)
+		l.Warnf("Unable to create volume req: %s", stripSecrets(req))
 		return nil, err
 	}
 
@@ -1279,6 +1516,11 @@ func (cp *ControllerPlugin) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 	vname := snameT[0]
 
+	if !cp.ownsVolume(vname) {
+		l.Warnf("Snapshot %s belongs to other instance, skip deletion", sname)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
 	//////////////////////////////////////////////////////////////////////////////
 
 	snap, err := cp.getSnapshot(sname)
@@ -1324,7 +1566,7 @@ func (cp *ControllerPlugin) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 	}
 
 	// Clean snapshot record
-	cp.delSnapshotRecord(snameT[1])
+	cp.meta.DeleteSnapshot(snameT[1])
 
 	return &csi.DeleteSnapshotResponse{}, nil
 
@@ -1335,7 +1577,7 @@ func (cp *ControllerPlugin) ListSnapshots(ctx context.Context, req *csi.ListSnap
 	l := cp.l.WithFields(logrus.Fields{
 		"func": "ListSnapshots",
 	})
-	msg := fmt.Sprintf("List snapshots %+v", req)
+	msg := fmt.Sprintf("List snapshots %s", stripSecrets(req))
 	l.Tracef(msg)
 	var err error
 
@@ -1355,7 +1597,7 @@ func (cp *ControllerPlugin) ListSnapshots(ctx context.Context, req *csi.ListSnap
 	if len(sname) != 0 {
 		s, err := cp.getSnapshot(sname)
 
-		if err != nil {
+		if err != nil || !cp.ownsSnapshot(sname) {
 			return &csi.ListSnapshotsResponse{
 				Entries: []*csi.ListSnapshotsResponse_Entry{},
 			}, nil
@@ -1414,7 +1656,10 @@ func (cp *ControllerPlugin) ListSnapshots(ctx context.Context, req *csi.ListSnap
 		if len(snameT) != 2 {
 			return false
 		}
-		return true
+		if isPolicySnapshot(s) && !cp.cfg.ListPolicySnapshots {
+			return false
+		}
+		return cp.ownsVolume(snameT[0])
 	}
 
 	var snapshots []rest.SnapshotShort
@@ -1497,6 +1742,9 @@ func (cp *ControllerPlugin) ListSnapshots(ctx context.Context, req *csi.ListSnap
 }
 
 // ControllerPublishVolume create iscsi target for the volume
+//
+// Volume is exported through a single target shared by all nodes
+// it is published to, every node gets its own CHAP user on that target
 func (cp *ControllerPlugin) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	l := cp.l.WithFields(logrus.Fields{
 		"func": "ControllerPublishVolume",
@@ -1514,26 +1762,32 @@ func (cp *ControllerPlugin) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
-	if len(vname) != 64 {
+	nfs := isNFSVolume(vname)
+	static := isStaticVolume(vname)
+	if !cp.ownsVolume(vname) && !nfs && !static {
 		msg := fmt.Sprintf("Volume id %s is incorrect", vname)
 		l.Warn(msg)
 		// Get universal volume ID
-		vname = cp.getStandardId(cp.cfg.Salt, vname)
+		vname = cp.volumeID(vname)
 
 	}
-	// TODO: verify capabiolity
 	caps := req.GetVolumeCapability()
 	if caps == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
 
+	if err = validateVolumeCapability(caps, nfs); err != nil {
+		l.Warn(err.Error())
+		return nil, err
+	}
+
 	if false == cp.capSupported(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME) {
 		err = status.Errorf(codes.Internal, "Capability is not supported.")
-		l.Warnf("Unable to publish volume req: %v", req)
+		l.Warnf("Unable to publish volume req: %s", stripSecrets(req))
 		return nil, err
 	}
 
-	roMode := req.GetReadonly()
+	roMode := req.GetReadonly() || isReadOnlyMode(caps.GetAccessMode().GetMode())
 
 	// Check node prefix
 	nID := req.GetNodeId()
@@ -1555,69 +1809,169 @@ func (cp *ControllerPlugin) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.NotFound, msg)
 
 	}
+
+	if nfs {
+		return cp.publishNFS(l, vname, nID, caps, roMode)
+	}
+
+	if static {
+		if err = cp.validateStaticVolume(vname); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+	}
+
+	// Encrypted volume is accessible only once its key is loaded
+	if err = cp.loadVolumeKey(l, storageVolume(vname), req.GetSecrets()); err != nil {
+		return nil, err
+	}
+
+	// Address part of node id is used only by NFS
+	nName, _ := parseNodeID(nID)
+
+	if req.GetVolumeContext()["transport"] == TransportNVMeTCP {
+		return cp.publishNVMe(l, vname, nName, caps, roMode)
+	}
+
+	chapSecret := getChapSecret(req.GetSecrets(), cp.cfg.ChapSecret)
+	if len(chapSecret) == 0 {
+		msg := "CHAP secret is not configured"
+		l.Warn(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
 	//////////////////////////////////////////////////////////////////////////////
 
+	// Target is shared among nodes, protect it from concurrent modifications
+	if err = cp.lockVolume(vname); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vname)
+
 	// Check if volume exists
-	_, err = cp.getVolume(vname)
+	zvol := storageVolume(vname)
+	_, err = cp.getVolume(zvol)
 
 	if err != nil {
 
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
 
-	// Create target
+	// Data written during flatten would be lost once volume is replaced
+	if !static {
+		if err = cp.checkNotFlattening(vname); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
+	}
 
 	tname := fmt.Sprintf("%s:%s", cp.iqn, vname)
+	chap := getChapCredentials(chapSecret, vname, nName)
 
-	rErr := (*cp.endpoints[0]).CreateTarget(tname)
-
+	// Check if target already exists
+	tExists := true
+	_, rErr := (*cp.endpoints[0]).GetTarget(tname)
 	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err = status.Errorf(codes.Internal, rErr.Error())
-			return nil, err
-
-		case rest.RestObjectExists:
-			l.Error(rErr.Error())
-			err = status.Errorf(codes.AlreadyExists, rErr.Error())
-			return nil, err
+		switch rErr.GetCode() {
 		case rest.RestResourceDNE:
-			msg := fmt.Sprintf("Resource not found: %s", rErr.Error())
-			err = status.Errorf(codes.Internal, msg)
-			return nil, err
-
+			tExists = false
 		default:
-			err = status.Errorf(codes.Internal, "Unknown internal error")
-			return nil, err
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	uExists := false
+	if tExists {
+		var users []rest.TargetUser
+		users, rErr = (*cp.endpoints[0]).GetTargetUsers(tname)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+
+		for _, u := range users {
+			if u.Name == chap.User {
+				uExists = true
+				continue
+			}
+			if isMultiNodeMode(caps.GetAccessMode().GetMode()) == false {
+				msg := fmt.Sprintf("Volume %s is published to other node", vname)
+				l.Warn(msg)
+				return nil, status.Error(codes.FailedPrecondition, msg)
+			}
+		}
+	} else {
+		// Create target
+		rErr = (*cp.endpoints[0]).CreateTarget(tname)
+
+		if rErr != nil {
+			code := rErr.GetCode()
+			switch code {
+			case rest.RestResourceBusy:
+				//According to specification from
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestFailureUnknown:
+				err = status.Errorf(codes.Internal, rErr.Error())
+				return nil, err
+
+			case rest.RestObjectExists:
+				l.Error(rErr.Error())
+				err = status.Errorf(codes.AlreadyExists, rErr.Error())
+				return nil, err
+			case rest.RestResourceDNE:
+				msg := fmt.Sprintf("Resource not found: %s", rErr.Error())
+				err = status.Errorf(codes.Internal, msg)
+				return nil, err
+
+			default:
+				err = status.Errorf(codes.Internal, "Unknown internal error")
+				return nil, err
+			}
+		}
+
+		// Set credentials target uses to authenticate itself (mutual CHAP)
+		rErr = (*cp.endpoints[0]).SetTargetOutgoingUser(tname, chap.TUser, chap.TPass)
+
+		if rErr != nil {
+			code := rErr.GetCode()
+			switch code {
+			case rest.RestResourceBusy:
+				//According to specification from
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestFailureUnknown:
+				err = status.Errorf(codes.Internal, rErr.Error())
+				return nil, err
+			case rest.RestResourceDNE:
+				msg := fmt.Sprintf("Resource not found: %s", rErr.Error())
+				err = status.Errorf(codes.Internal, msg)
+				return nil, err
+			default:
+				err = status.Errorf(codes.Internal, "Unknown internal error")
+				return nil, err
+			}
 		}
 	}
 
 	// Set Password
-	uname := cp.getRandomName(12)
-	pass := cp.getRandomPassword(16)
-	rErr = (*cp.endpoints[0]).AddUserToTarget(tname, uname, pass)
+	if uExists == false {
+		rErr = (*cp.endpoints[0]).AddUserToTarget(tname, chap.User, chap.Pass)
 
-	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err = status.Errorf(codes.Internal, rErr.Error())
-			return nil, err
+		if rErr != nil {
+			code := rErr.GetCode()
+			switch code {
+			case rest.RestResourceBusy:
+				//According to specification from
+				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestFailureUnknown:
+				err = status.Errorf(codes.Internal, rErr.Error())
+				return nil, err
 
-		case rest.RestObjectExists:
-			err = status.Errorf(codes.AlreadyExists, rErr.Error())
-			return nil, err
+			case rest.RestObjectExists:
+				err = status.Errorf(codes.AlreadyExists, rErr.Error())
+				return nil, err
 
-		default:
-			err = status.Errorf(codes.Internal, "Unknown internal error")
-			return nil, err
+			default:
+				err = status.Errorf(codes.Internal, "Unknown internal error")
+				return nil, err
+			}
 		}
 	}
 
@@ -1629,64 +1983,121 @@ func (cp *ControllerPlugin) ControllerPublishVolume(ctx context.Context, req *cs
 		mode = "wt"
 	}
 
-	rErr = (*cp.endpoints[0]).AttachToTarget(tname, vname, mode)
-
-	if rErr != nil {
-		code := rErr.GetCode()
-		switch code {
-		case rest.RestResourceBusy:
-			//According to specification from
-			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
-		case rest.RestFailureUnknown:
-			err = status.Errorf(codes.Internal, rErr.Error())
-			return nil, err
-		default:
-			err = status.Errorf(codes.Internal, "Unknown internal error")
-			return nil, err
+	lAttached := false
+	if tExists {
+		var luns []rest.TargetLun
+		luns, rErr = (*cp.endpoints[0]).GetTargetLuns(tname)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		for _, lun := range luns {
+			if lun.Name != zvol {
+				continue
+			}
+			// Lun mode is shared by all nodes using target
+			if lun.Mode != mode {
+				msg := fmt.Sprintf("Volume %s is already published in %s mode", vname, lun.Mode)
+				l.Warn(msg)
+				return nil, status.Error(codes.AlreadyExists, msg)
+			}
+			lAttached = true
 		}
 	}
-	secrets := map[string]string{"name": uname, "pass": pass}
 
-	secrets["iqn"] = cp.iqn
-	secrets["target"] = strings.ToLower(vname)
+	if lAttached == false {
+		rErr = (*cp.endpoints[0]).AttachToTarget(tname, zvol, mode)
 
-	var target *rest.Target
-	for i := 0; i < 3; i++ {
-		target, rErr = (*cp.endpoints[0]).GetTarget(tname)
 		if rErr != nil {
 			code := rErr.GetCode()
 			switch code {
-			case rest.RestResourceDNE:
+			case rest.RestResourceBusy:
 				//According to specification from
 				return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+			case rest.RestFailureUnknown:
+				err = status.Errorf(codes.Internal, rErr.Error())
+				return nil, err
 			default:
-				return nil, status.Errorf(codes.Internal, rErr.Error())
+				err = status.Errorf(codes.Internal, "Unknown internal error")
+				return nil, err
 			}
 		}
-		if target.Active == true {
-			l.Tracef("Target %s is active", tname)
-			break
-		}
-		time.Sleep(time.Second)
 	}
-	if target.Active == false {
-		return nil, status.Errorf(codes.Internal, "Unable to make target ready")
+
+	// CHAP credentials are derived by the node from the shared secret
+	// and must not be a part of publish context
+	pCtx := map[string]string{}
+
+	pCtx["iqn"] = cp.iqn
+	pCtx["target"] = strings.ToLower(vname)
+	if roMode {
+		pCtx["readonly"] = "true"
 	}
+
+	op := cp.ops.run(ctx, "ControllerPublishVolume/"+tname, func(op *operation) error {
+		return cp.waitTargetActive(tname)
+	})
+	if !op.Done() {
+		msg := fmt.Sprintf("Target %s is not active yet", tname)
+		l.Trace(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	if err = op.Err(); err != nil {
+		return nil, err
+	}
+	l.Tracef("Target %s is active", tname)
 	//TODO: add target ip
 	// target port
 	resp := &csi.ControllerPublishVolumeResponse{
-		PublishContext: secrets,
+		PublishContext: pCtx,
 	}
 	return resp, nil
 }
 
+// waitTargetActive waits until storage activates target
+func (cp *ControllerPlugin) waitTargetActive(tname string) error {
+	deadline := time.Now().Add(targetActivationTimeout)
+	for {
+		target, rErr := (*cp.endpoints[0]).GetTarget(tname)
+		if rErr != nil {
+			switch rErr.GetCode() {
+			case rest.RestResourceDNE:
+				//According to specification from
+				return status.Error(codes.FailedPrecondition, rErr.Error())
+			default:
+				return status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+		if target.Active {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return status.Errorf(codes.Internal, "Unable to make target ready")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// volumeTransport returns transport volume is published with,
+// known is false if volume record does not tell it, records made
+// before parameters were stored have none of them
+func (cp *ControllerPlugin) volumeTransport(vID string) (transport string, known bool) {
+	rec, err := cp.meta.GetVolume(vID)
+	if err != nil || rec == nil || len(rec.Parameters) == 0 {
+		return "", false
+	}
+	return rec.Parameters["transport"], true
+}
+
 // ControllerUnpublishVolume remove iscsi target for the volume
+//
+// Removes CHAP user of the node from the target,
+// target itself is deleted once no nodes are using it
 func (cp *ControllerPlugin) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	l := cp.l.WithFields(logrus.Fields{
 		"func": "UnpublishVolume",
 	})
 
-	l.Tracef("UnpublishVolume req: %+v", req)
+	l.Tracef("UnpublishVolume req: %s", stripSecrets(req))
 	var err error
 
 	//////////////////////////////////////////////////////////////////////////////
@@ -1698,10 +2109,91 @@ func (cp *ControllerPlugin) ControllerUnpublishVolume(ctx context.Context, req *
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
+	nID := req.GetNodeId()
+
+	if !cp.ownsVolume(vname) && !isStaticVolume(vname) {
+		msg := fmt.Sprintf("Volume %s belongs to other instance", vname)
+		l.Warn(msg)
+		return nil, status.Error(codes.NotFound, msg)
+	}
+
 	//////////////////////////////////////////////////////////////////////////////
 
+	if err = cp.lockVolume(vname); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vname)
+
+	if isNFSVolume(vname) {
+		return cp.unpublishNFS(l, vname, nID)
+	}
+
+	// Address part of node id is used only by NFS
+	nID, _ = parseNodeID(nID)
+
+	// Request do not carry volume context, transport is taken from
+	// parameters in volume record, or identified by storage without it
+	transport, known := cp.volumeTransport(storageVolume(vname))
+	if transport == TransportNVMeTCP {
+		return cp.unpublishNVMe(l, vname, nID)
+	}
+
 	tname := fmt.Sprintf("%s:%s", cp.iqn, vname)
-	rErr := (*cp.endpoints[0]).DettachFromTarget(tname, vname)
+
+	users, rErr := (*cp.endpoints[0]).GetTargetUsers(tname)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			if known {
+				// Volume is not published
+				return &csi.ControllerUnpublishVolumeResponse{}, nil
+			}
+			// Subsystem is looked for only once there is no target,
+			// so storage without NVMe support is not asked for it
+			sname := fmt.Sprintf("%s:%s", cp.nqn, vname)
+			_, rErr = (*cp.endpoints[0]).GetNVMeSubsystem(sname)
+			if rErr == nil {
+				return cp.unpublishNVMe(l, vname, nID)
+			}
+			if rErr.GetCode() != rest.RestResourceDNE {
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+			// Volume is not published
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	// Empty node id means volume should be unpublished from all nodes
+	if len(nID) > 0 {
+		chapSecret := getChapSecret(req.GetSecrets(), cp.cfg.ChapSecret)
+		if len(chapSecret) == 0 {
+			msg := "CHAP secret is not configured"
+			l.Warn(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+		uname := getChapCredentials(chapSecret, vname, nID).User
+
+		remaining := 0
+		for _, u := range users {
+			if u.Name != uname {
+				remaining++
+				continue
+			}
+			rErr = (*cp.endpoints[0]).DeleteUserFromTarget(tname, uname)
+			if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+				return nil, status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+
+		if remaining > 0 {
+			l.Tracef("Volume %s is still published to %d nodes", vname, remaining)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+	}
+
+	rErr = (*cp.endpoints[0]).DettachFromTarget(tname, storageVolume(vname))
 
 	if rErr != nil {
 		c := rErr.GetCode()
@@ -1751,7 +2243,6 @@ func (cp *ControllerPlugin) ValidateVolumeCapabilities(
 	req *csi.ValidateVolumeCapabilitiesRequest) (
 	*csi.ValidateVolumeCapabilitiesResponse, error) {
 
-	supported := true
 	vname := req.GetVolumeId()
 	if len(vname) == 0 {
 		msg := "Volume name missing in request"
@@ -1759,7 +2250,13 @@ func (cp *ControllerPlugin) ValidateVolumeCapabilities(
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
-	_, err := cp.getVolume(vname)
+	var err error
+	nfs := isNFSVolume(vname)
+	if nfs {
+		_, err = cp.getNFSVolume(vname)
+	} else {
+		_, err = cp.getVolume(storageVolume(vname))
+	}
 
 	if err != nil {
 
@@ -1773,32 +2270,18 @@ func (cp *ControllerPlugin) ValidateVolumeCapabilities(
 	}
 
 	for _, c := range vcap {
-		m := c.GetAccessMode()
-		pass := false
-		for _, mode := range supportedVolumeCapabilities {
-			if mode == m.Mode {
-				pass = true
-			}
-		}
-		if pass == false {
-			supported = false
-			break
+		if err = validateVolumeCapability(c, nfs); err != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Message: err.Error(),
+			}, nil
 		}
-
-	}
-
-	if supported != true {
-
 	}
 
 	vCtx := req.GetVolumeContext()
-	if vcap == nil {
-		return nil, status.Error(codes.InvalidArgument, "Volume context where not specified")
-	}
 
 	resp := &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: cp.vCap,
+			VolumeCapabilities: vcap,
 			VolumeContext:      vCtx,
 		},
 	}
@@ -1843,6 +2326,61 @@ func (cp *ControllerPlugin) capSupported(c csi.ControllerServiceCapability_RPC_T
 	return false
 }
 
+// isMultiNodeMode checks if access mode allows volume to be published to several nodes
+func isMultiNodeMode(m csi.VolumeCapability_AccessMode_Mode) bool {
+	switch m {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// isReadOnlyMode checks if access mode forbids writing to volume
+func isReadOnlyMode(m csi.VolumeCapability_AccessMode_Mode) bool {
+	switch m {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// validateVolumeCapability checks if access mode is supported for given access type
+//
+// NFS volumes are file systems that can be written by several nodes
+func validateVolumeCapability(c *csi.VolumeCapability, nfs bool) error {
+	m := c.GetAccessMode().GetMode()
+
+	supported := false
+	for _, mode := range supportedVolumeCapabilities {
+		if mode == m {
+			supported = true
+		}
+	}
+	if supported == false {
+		msg := fmt.Sprintf("Access mode %s is not supported", m)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+
+	if nfs {
+		if c.GetBlock() != nil {
+			msg := fmt.Sprintf("Block access is not supported for NFS volumes")
+			return status.Error(codes.InvalidArgument, msg)
+		}
+		return nil
+	}
+
+	// Several writers would corrupt file system
+	if m == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER && c.GetBlock() == nil {
+		msg := fmt.Sprintf("Access mode %s is supported only for block volumes", m)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+
+	return nil
+}
+
 // GetVolumeCapability volume related capabilities
 func GetVolumeCapability(vcam []csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability {
 	var out []*csi.VolumeCapability
@@ -1874,7 +2412,7 @@ func (cp *ControllerPlugin) ControllerGetCapabilities(ctx context.Context, req *
 	*csi.ControllerGetCapabilitiesResponse,
 	error,
 ) {
-	cp.l.WithField("func", "ControllerGetCapabilities()").Infof("request: '%+v'", req)
+	cp.l.WithField("func", "ControllerGetCapabilities()").Infof("request: '%s'", stripSecrets(req))
 
 	var capabilities []*csi.ControllerServiceCapability
 	for _, c := range supportedControllerCapabilities {
@@ -0,0 +1,145 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RollbackVolume reverts unpublished volume to its snapshot
+func (cp *ControllerPlugin) RollbackVolume(ctx context.Context, req *RollbackVolumeRequest) (*RollbackVolumeResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "RollbackVolume",
+	})
+
+	vID := req.VolumeID
+	sID := req.SnapshotID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if len(sID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot id missing in request")
+	}
+	if isNFSVolume(vID) || strings.HasPrefix(vID, "c_") || !cp.ownsVolume(vID) {
+		msg := fmt.Sprintf("Volume %s can not be rolled back", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	if snameT := strings.Split(sID, "_"); len(snameT) != 2 || snameT[0] != vID {
+		msg := fmt.Sprintf("Snapshot %s does not belong to volume %s", sID, vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	// Deletion of newer snapshots and rollback itself might take a while,
+	// retry reattaches only to rollback to the same snapshot
+	key := fmt.Sprintf("RollbackVolume/%s/%s/%t", vID, sID, req.Force)
+	op := cp.ops.run(ctx, key, func(op *operation) error {
+		if err := cp.lockVolume(vID); err != nil {
+			return err
+		}
+		defer cp.unlockVolume(vID)
+
+		return cp.rollbackVolume(l, vID, sID, req.Force)
+	})
+	if !op.Done() {
+		msg := fmt.Sprintf("Rollback of volume %s is in progress", vID)
+		l.Trace(msg)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	if err := op.Err(); err != nil {
+		return nil, err
+	}
+	return &RollbackVolumeResponse{}, nil
+}
+
+// rollbackVolume reverts locked volume to its snapshot
+//
+// Newer snapshots are deleted only if force is set,
+// snapshots that have clones are never deleted.
+func (cp *ControllerPlugin) rollbackVolume(l *logrus.Entry, vID string, sID string, force bool) error {
+	if err := cp.checkUnpublished(vID); err != nil {
+		l.Warn(err.Error())
+		return err
+	}
+
+	if err := cp.checkNotFlattening(vID); err != nil {
+		return err
+	}
+
+	s, err := cp.getSnapshot(sID)
+	if err != nil {
+		return err
+	}
+	created, rErr := rest.GetTimeStamp(s.Creation)
+	if rErr != nil {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	snaps, err := cp.getVolumeAllSnapshots(vID)
+	if err != nil {
+		return err
+	}
+
+	newer := []rest.SnapshotShort{}
+	public := []string{}
+	for _, ns := range snaps {
+		if ns.Name == sID {
+			continue
+		}
+		t, rErr := rest.GetTimeStamp(ns.Properties.Creation)
+		if rErr != nil {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+		if t <= created {
+			continue
+		}
+		if len(splitClones(ns.Clones)) > 0 {
+			msg := fmt.Sprintf("Snapshot %s of volume %s has clones %s", ns.Name, vID, ns.Clones)
+			l.Warn(msg)
+			return status.Error(codes.FailedPrecondition, msg)
+		}
+		if !strings.HasPrefix(ns.Name, "c_") {
+			public = append(public, ns.Name)
+		}
+		newer = append(newer, ns)
+	}
+
+	if len(public) > 0 && !force {
+		msg := fmt.Sprintf("Volume %s has newer snapshots: %s", vID, strings.Join(public, " "))
+		l.Warn(msg)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+
+	for _, ns := range newer {
+		if isReplicationSnapshot(ns.Name) {
+			// Replica gets full stream once base snapshot is gone
+			(*cp.endpoints[0]).DeleteReplicationTask(vID, replicationTaskName(ns.Name))
+		}
+		rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, ns.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+		l.Tracef("Snapshot %s deleted", ns.Name)
+	}
+
+	rErr = (*cp.endpoints[0]).RollbackVolume(vID, sID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return status.Error(codes.NotFound, rErr.Error())
+		case rest.RestResourceBusy:
+			return status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	l.Tracef("Volume %s rolled back to %s", vID, sID)
+	return nil
+}
@@ -0,0 +1,176 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Static volumes are zvols created without the plugin.
+// Their id is static-<zvol name>, so they are never confused with
+// volumes provisioned by the plugin. Plugin publishes and stages them
+// as any other volume, but deletes only ones that were adopted.
+
+const (
+	staticVolumePrefix = "static-"
+
+	// maxIqnLen limits target name made of static volume id
+	maxIqnLen = 223
+)
+
+var (
+	// pluginVolumeRe matches zvols of any plugin instance
+	pluginVolumeRe = regexp.MustCompile(`^([a-z0-9-]+-)?[0-9a-f]{64}$`)
+	// staticVolumeRe matches zvol names usable in target name
+	staticVolumeRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.:-]*$`)
+)
+
+// isStaticVolume checks if volume id refers to pre-existing zvol
+func isStaticVolume(vID string) bool {
+	return strings.HasPrefix(vID, staticVolumePrefix) && len(vID) > len(staticVolumePrefix)
+}
+
+// storageVolume returns name of zvol volume id refers to
+func storageVolume(vID string) string {
+	if isStaticVolume(vID) {
+		return strings.TrimPrefix(vID, staticVolumePrefix)
+	}
+	return vID
+}
+
+// checkStaticVolumeName checks that zvol is not managed by any plugin
+// instance and its name is allowed in iSCSI target name
+func checkStaticVolumeName(zvol string) error {
+	if strings.HasPrefix(zvol, "c_") || isNFSVolume(zvol) || pluginVolumeRe.MatchString(zvol) {
+		msg := fmt.Sprintf("Volume %s is managed by plugin and can not be used as static", zvol)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if !staticVolumeRe.MatchString(zvol) {
+		msg := fmt.Sprintf("Volume name %s is incorrect, "+
+			"static volume name should consist of lowercase letters, digits, dots, colons and dashes", zvol)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// validateStaticVolume checks that static volume refers to existing zvol
+// that is not managed by the plugin
+func (cp *ControllerPlugin) validateStaticVolume(vID string) error {
+	zvol := storageVolume(vID)
+	if err := checkStaticVolumeName(zvol); err != nil {
+		return err
+	}
+	if len(cp.iqn)+1+len(vID) > maxIqnLen {
+		msg := fmt.Sprintf("Volume name %s is too long for target name", zvol)
+		return status.Error(codes.InvalidArgument, msg)
+	}
+
+	if _, err := cp.getVolume(zvol); err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return nil
+}
+
+// deleteStaticVolume deletes zvol of static volume if it was adopted
+func (cp *ControllerPlugin) deleteStaticVolume(l *logrus.Entry, vID string) (*csi.DeleteVolumeResponse, error) {
+	zvol := storageVolume(vID)
+
+	if err := cp.lockVolume(vID); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vID)
+
+	rec, err := cp.meta.GetVolume(zvol)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		if _, err = cp.getVolume(zvol); status.Code(err) == codes.NotFound {
+			l.Tracef("Static volume %s already deleted", vID)
+			return &csi.DeleteVolumeResponse{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rec = &VolumeRecord{ID: zvol}
+	}
+
+	if !rec.Adopted || rec.Instance != cp.instance {
+		msg := fmt.Sprintf("Static volume %s is not adopted and will not be deleted", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+
+	rErr := (*cp.endpoints[0]).DeleteVolume(zvol, false)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	l.Tracef("Static volume %s deleted", vID)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// AdoptVolume allows plugin to delete static volume
+func (cp *ControllerPlugin) AdoptVolume(ctx context.Context, req *AdoptVolumeRequest) (*AdoptVolumeResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "AdoptVolume",
+	})
+
+	if !isStaticVolume(req.VolumeID) {
+		msg := fmt.Sprintf("Volume %s is not static", req.VolumeID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	if err := cp.lockVolume(req.VolumeID); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(req.VolumeID)
+
+	if err := cp.validateStaticVolume(req.VolumeID); err != nil {
+		l.Warn(err.Error())
+		return nil, err
+	}
+
+	zvol := storageVolume(req.VolumeID)
+	rec, err := cp.meta.GetVolume(zvol)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		rec = &VolumeRecord{
+			ID:        zvol,
+			Ownership: volumeOwnership(nil, cp.instance),
+		}
+	}
+
+	if rec.Adopted && rec.Instance != cp.instance {
+		msg := fmt.Sprintf("Volume %s is adopted by instance %s", req.VolumeID, rec.Instance)
+		l.Warn(msg)
+		return nil, status.Error(codes.FailedPrecondition, msg)
+	}
+
+	rec.Adopted = true
+	rec.Instance = cp.instance
+	if len(rec.Name) == 0 {
+		rec.Name = req.VolumeID
+	}
+	if err = cp.meta.PutVolume(*rec); err != nil {
+		return nil, err
+	}
+
+	l.Tracef("Volume %s adopted", req.VolumeID)
+	return &AdoptVolumeResponse{}, nil
+}
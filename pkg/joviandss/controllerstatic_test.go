@@ -0,0 +1,32 @@
+package joviandss
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStaticVolumeName(t *testing.T) {
+	hash := strings.Repeat("0a", 32)
+
+	valid := []string{"data", "db-1", "vol.2", "backup:01"}
+	for _, zvol := range valid {
+		if err := checkStaticVolumeName(zvol); err != nil {
+			t.Errorf("%s is rejected: %s", zvol, err)
+		}
+	}
+
+	invalid := []string{
+		hash,
+		"other-" + hash,
+		"c_" + hash,
+		"nfs-data",
+		"Data",
+		"my_vol",
+		"-data",
+	}
+	for _, zvol := range invalid {
+		if err := checkStaticVolumeName(zvol); err == nil {
+			t.Errorf("%s is accepted", zvol)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package joviandss
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Storage class parameter encryption makes volumes encrypted zvols.
+// With encryption secret key of volume is derived from CSI secret,
+// so the same secret has to be given to provisioner and controller publish.
+// With encryption kms key is random and kept by KMS set in controller config.
+//
+// Clones share key of their source, volume record keeps reference
+// to the key, <mode>:<key id>, key id is id of volume key was made for.
+
+const (
+	paramEncryption = "encryption"
+
+	// EncryptionSecret key is derived from CSI secret
+	EncryptionSecret = "secret"
+	// EncryptionKMS key is kept by KMS
+	EncryptionKMS = "kms"
+
+	// EncryptionSecretKey name of the key in CSI secrets that holds encryption secret
+	EncryptionSecretKey = "encryptionsecret"
+
+	volumeKeyLen = 32
+)
+
+// ErrKeyNotFound is returned by KMS that has no requested key
+var ErrKeyNotFound = errors.New("Key not found")
+
+// KMS keeps encryption keys of volumes
+type KMS interface {
+	// CreateKey makes new key, or returns existing key with the same id
+	CreateKey(keyID string) (string, error)
+	// GetKey returns key with given id
+	GetKey(keyID string) (string, error)
+}
+
+// KMSCfg describes KMS
+type KMSCfg struct {
+	Type string // file
+	Dir  string // directory of file KMS
+}
+
+// newKMS creates KMS described by config, nil if there is none
+func newKMS(cfg KMSCfg) (KMS, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "file":
+		return newFileKMS(cfg.Dir)
+	}
+	return nil, fmt.Errorf("KMS type %s is not supported", cfg.Type)
+}
+
+// isEncryptionSupported checks value of encryption parameter
+func isEncryptionSupported(mode string) bool {
+	switch mode {
+	case "", EncryptionSecret, EncryptionKMS:
+		return true
+	}
+	return false
+}
+
+// isVolumeEncrypted checks if zvol is encrypted
+func isVolumeEncrypted(v *rest.Volume) bool {
+	return len(v.Encryption) > 0 && v.Encryption != rest.VolumeEncryptionOff
+}
+
+func volumeKeyRef(mode string, keyID string) string {
+	return mode + ":" + keyID
+}
+
+// encryptionKeyRef returns reference to key of new volume,
+// clones use key of their source
+func (cp *ControllerPlugin) encryptionKeyRef(vID string, req *csi.CreateVolumeRequest) (string, error) {
+	src := ""
+	if s := req.GetVolumeContentSource().GetSnapshot(); s != nil {
+		src = strings.Split(s.GetSnapshotId(), "_")[0]
+	} else if v := req.GetVolumeContentSource().GetVolume(); v != nil {
+		src = v.GetVolumeId()
+	}
+
+	if len(src) == 0 {
+		mode := req.GetParameters()[paramEncryption]
+		if len(mode) == 0 {
+			return "", nil
+		}
+		return volumeKeyRef(mode, vID), nil
+	}
+
+	rec, err := cp.meta.GetVolume(src)
+	if err != nil || rec == nil {
+		return "", err
+	}
+	return rec.EncryptionKey, nil
+}
+
+// checkPoolEncryption makes sure pool allows encrypted volumes
+func (cp *ControllerPlugin) checkPoolEncryption() error {
+	pool, rErr := (*cp.endpoints[0]).GetPool()
+	if rErr != nil {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+	if !pool.Encryption.Enabled {
+		return status.Errorf(codes.InvalidArgument, "Encryption is not enabled on pool %s", pool.Name)
+	}
+	return nil
+}
+
+// deriveVolumeKey makes hex encoded key of volume out of secret
+func deriveVolumeKey(secret string, keyID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("key:" + keyID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createVolumeKey makes key for new volume
+func (cp *ControllerPlugin) createVolumeKey(mode string, vID string, secrets map[string]string) (string, error) {
+	if mode == EncryptionKMS {
+		if cp.kms == nil {
+			return "", status.Error(codes.InvalidArgument, "KMS is not configured")
+		}
+		key, err := cp.kms.CreateKey(vID)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "Unable to create key of volume %s: %s", vID, err)
+		}
+		return key, nil
+	}
+	return cp.getVolumeKey(volumeKeyRef(mode, vID), secrets)
+}
+
+// getVolumeKey provides key by its reference
+func (cp *ControllerPlugin) getVolumeKey(ref string, secrets map[string]string) (string, error) {
+	refT := strings.SplitN(ref, ":", 2)
+	if len(refT) != 2 {
+		return "", status.Errorf(codes.FailedPrecondition, "Key reference %s is incorrect", ref)
+	}
+	mode, keyID := refT[0], refT[1]
+
+	switch mode {
+	case EncryptionSecret:
+		secret := secrets[EncryptionSecretKey]
+		if len(secret) == 0 {
+			return "", status.Errorf(codes.FailedPrecondition,
+				"Encryption secret of volume %s is missing", keyID)
+		}
+		return deriveVolumeKey(secret, keyID), nil
+	case EncryptionKMS:
+		if cp.kms == nil {
+			return "", status.Error(codes.FailedPrecondition, "KMS is not configured")
+		}
+		key, err := cp.kms.GetKey(keyID)
+		if err != nil {
+			return "", status.Errorf(codes.FailedPrecondition, "Key of volume %s is missing: %s", keyID, err)
+		}
+		return key, nil
+	}
+	return "", status.Errorf(codes.FailedPrecondition, "Key reference %s is incorrect", ref)
+}
+
+// loadVolumeKey makes sure key of encrypted volume is loaded
+//
+// Key is loaded for encryption root, volume itself or source of a clone
+func (cp *ControllerPlugin) loadVolumeKey(l *logrus.Entry, vID string, secrets map[string]string) error {
+	v, err := cp.getVolume(vID)
+	if err != nil {
+		return err
+	}
+	if !isVolumeEncrypted(v) || v.Keystatus == rest.VolumeKeyAvailable {
+		return nil
+	}
+
+	rec, err := cp.meta.GetVolume(vID)
+	if err != nil {
+		return err
+	}
+	if rec == nil || len(rec.EncryptionKey) == 0 {
+		msg := fmt.Sprintf("Key of volume %s is missing", vID)
+		l.Warn(msg)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+
+	key, err := cp.getVolumeKey(rec.EncryptionKey, secrets)
+	if err != nil {
+		l.Warn(err.Error())
+		return err
+	}
+
+	root := vID
+	if len(v.Encryptionroot) > 0 {
+		root = v.Encryptionroot[strings.LastIndex(v.Encryptionroot, "/")+1:]
+	}
+
+	rErr := (*cp.endpoints[0]).LoadVolumeKey(root, key)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return status.Error(codes.NotFound, rErr.Error())
+		case rest.RestWrongKey:
+			return status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+	l.Tracef("Key of volume %s loaded", root)
+	return nil
+}
+
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// fileKMS keeps keys as files of directory, one key per file
+type fileKMS struct {
+	dir string
+}
+
+func newFileKMS(dir string) (*fileKMS, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("Directory of file KMS is not set")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileKMS{dir: dir}, nil
+}
+
+func (k *fileKMS) path(keyID string) (string, error) {
+	if !keyIDRe.MatchString(keyID) {
+		return "", fmt.Errorf("Key id %s is incorrect", keyID)
+	}
+	return filepath.Join(k.dir, keyID), nil
+}
+
+func (k *fileKMS) CreateKey(keyID string) (string, error) {
+	if key, err := k.GetKey(keyID); err != ErrKeyNotFound {
+		return key, err
+	}
+
+	raw := make([]byte, volumeKeyLen)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+
+	p, err := k.path(keyID)
+	if err != nil {
+		return "", err
+	}
+
+	// Key is written to temporary file first, so it is never seen partially
+	f, err := ioutil.TempFile(k.dir, "."+keyID)
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.WriteString(key); err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err = os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	// Volume is useless without its key, so key has to survive
+	// power loss before volume is created, rename is durable
+	// only after directory is synced
+	dir, err := os.Open(k.dir)
+	if err != nil {
+		return "", err
+	}
+	err = dir.Sync()
+	if cErr := dir.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (k *fileKMS) GetKey(keyID string) (string, error) {
+	p, err := k.path(keyID)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return "", ErrKeyNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,54 @@
+package joviandss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileKMS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	k, err := newFileKMS(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = k.GetKey("vol"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	key, err := k.CreateKey("vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 2*volumeKeyLen {
+		t.Fatalf("unexpected key length %d", len(key))
+	}
+
+	// Existing key is kept
+	if again, err := k.CreateKey("vol"); err != nil || again != key {
+		t.Fatalf("key is replaced: %v", err)
+	}
+	if got, err := k.GetKey("vol"); err != nil || got != key {
+		t.Fatalf("unexpected key: %v", err)
+	}
+
+	// Temporary files are not left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "vol" {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	if _, err = k.CreateKey("../vol"); err == nil {
+		t.Fatal("incorrect key id is accepted")
+	}
+}
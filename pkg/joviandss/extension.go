@@ -0,0 +1,477 @@
+package joviandss
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// Extension service provides storage features that are not covered by
+// CSI specification supported by the plugin.
+// Messages are encoded with JSON, so no generated code is required
+// for server or clients.
+
+const (
+	// ExtensionServiceName full name of extension gRPC service
+	ExtensionServiceName = "joviandss.v1.Extension"
+
+	jsonCodecName = "json"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return jsonCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// GroupMemberSnapshot snapshot of a single volume made as a part of group snapshot
+type GroupMemberSnapshot struct {
+	SnapshotID     string `json:"snapshot_id"`
+	SourceVolumeID string `json:"source_volume_id"`
+	CreationTime   int64  `json:"creation_time"`
+	SizeBytes      int64  `json:"size_bytes"`
+	ReadyToUse     bool   `json:"ready_to_use"`
+}
+
+// CreateVolumeGroupSnapshotRequest request to snapshot set of volumes at once
+type CreateVolumeGroupSnapshotRequest struct {
+	Name            string   `json:"name"`
+	SourceVolumeIDs []string `json:"source_volume_ids"`
+}
+
+// VolumeGroupSnapshot group of snapshots made at the same point in time
+type VolumeGroupSnapshot struct {
+	GroupSnapshotID string                `json:"group_snapshot_id"`
+	Snapshots       []GroupMemberSnapshot `json:"snapshots"`
+}
+
+// GetVolumeGroupSnapshotRequest request for group snapshot members
+type GetVolumeGroupSnapshotRequest struct {
+	GroupSnapshotID string `json:"group_snapshot_id"`
+}
+
+// DeleteVolumeGroupSnapshotRequest request to delete all members of group snapshot
+type DeleteVolumeGroupSnapshotRequest struct {
+	GroupSnapshotID string `json:"group_snapshot_id"`
+}
+
+// DeleteVolumeGroupSnapshotResponse empty response
+type DeleteVolumeGroupSnapshotResponse struct{}
+
+// LookupRequest request for objects with metadata property key equal to value
+//
+// Key is a property name without namespace: name, pvc-name, pvc-namespace, pv-name,
+// volumesnapshot-name, volumesnapshot-namespace, volumesnapshotcontent-name, instance, created or policy
+type LookupRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ObjectMetadata metadata of volume or snapshot
+type ObjectMetadata struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	SourceVolumeID string `json:"source_volume_id,omitempty"`
+	Owner          string `json:"owner,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	Content        string `json:"content,omitempty"`
+	Instance       string `json:"instance,omitempty"`
+	CreationTime   int64  `json:"creation_time,omitempty"`
+}
+
+// LookupResponse volumes and snapshots matching lookup request
+type LookupResponse struct {
+	Volumes   []ObjectMetadata `json:"volumes"`
+	Snapshots []ObjectMetadata `json:"snapshots"`
+}
+
+// AdoptVolumeRequest request to let plugin delete static volume
+type AdoptVolumeRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// AdoptVolumeResponse empty response
+type AdoptVolumeResponse struct{}
+
+// VolumeAncestor snapshot volume was cloned from
+type VolumeAncestor struct {
+	Volume    string `json:"volume"`
+	Snapshot  string `json:"snapshot"`
+	Concealed bool   `json:"concealed"`
+}
+
+// GetVolumeAncestryRequest request for clone chain of volume
+type GetVolumeAncestryRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// GetVolumeAncestryResponse ancestors of volume, nearest first
+type GetVolumeAncestryResponse struct {
+	VolumeID  string           `json:"volume_id"`
+	Ancestors []VolumeAncestor `json:"ancestors"`
+}
+
+// FlattenVolumeRequest request to make volume independent of its ancestors
+type FlattenVolumeRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// FlattenVolumeResponse state of flatten operation,
+// request should be repeated until state is done
+type FlattenVolumeResponse struct {
+	State    string `json:"state"`
+	Progress int    `json:"progress"`
+}
+
+// RollbackVolumeRequest request to revert volume to its snapshot
+//
+// Snapshots newer than the given one are deleted by rollback,
+// request is refused if there are any unless Force is set
+type RollbackVolumeRequest struct {
+	VolumeID   string `json:"volume_id"`
+	SnapshotID string `json:"snapshot_id"`
+	Force      bool   `json:"force"`
+}
+
+// RollbackVolumeResponse empty response
+type RollbackVolumeResponse struct{}
+
+// ReplicationStatusRequest request for replication state of volume
+type ReplicationStatusRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// ReplicationStatus state of volume replication to secondary storage
+//
+// LastSync is creation time of the latest snapshot received by replica,
+// Lag is number of seconds since then
+type ReplicationStatus struct {
+	VolumeID string `json:"volume_id"`
+	Interval string `json:"interval"`
+	State    string `json:"state"`
+	LastSync int64  `json:"last_sync"`
+	Lag      int64  `json:"lag"`
+	Progress int    `json:"progress"`
+	Message  string `json:"message,omitempty"`
+}
+
+// PromoteReplicaRequest request to make replica of volume writable
+type PromoteReplicaRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// PromoteReplicaResponse empty response
+type PromoteReplicaResponse struct{}
+
+// ExportSnapshotRequest request to store snapshot data outside of storage
+//
+// Target is URI of backup target, like file:///backups or s3://bucket/prefix,
+// Name is name of stream in target, snapshot id by default
+type ExportSnapshotRequest struct {
+	SnapshotID string `json:"snapshot_id"`
+	Target     string `json:"target"`
+	Name       string `json:"name,omitempty"`
+}
+
+// ExportSnapshotResponse describes stored stream
+type ExportSnapshotResponse struct {
+	Target string `json:"target"`
+	Name   string `json:"name"`
+}
+
+// ImportVolumeRequest request to create volume out of exported stream
+//
+// Name is CSI name of new volume, id of volume is derived from it
+type ImportVolumeRequest struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Stream string `json:"stream"`
+}
+
+// ImportVolumeResponse describes imported volume
+type ImportVolumeResponse struct {
+	VolumeID      string `json:"volume_id"`
+	CapacityBytes int64  `json:"capacity_bytes"`
+}
+
+// ModifyVolumeRequest request to change mutable parameters of volume
+//
+// Only IO limits, readIOPS, writeIOPS, readBandwidth and writeBandwidth,
+// can be modified, parameters missing in request are kept
+type ModifyVolumeRequest struct {
+	VolumeID   string            `json:"volume_id"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+// ModifyVolumeResponse empty response
+type ModifyVolumeResponse struct{}
+
+// ExtensionServer is implemented by plugins serving extension service
+type ExtensionServer interface {
+	CreateVolumeGroupSnapshot(context.Context, *CreateVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error)
+	GetVolumeGroupSnapshot(context.Context, *GetVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error)
+	DeleteVolumeGroupSnapshot(context.Context, *DeleteVolumeGroupSnapshotRequest) (*DeleteVolumeGroupSnapshotResponse, error)
+	LookupObjects(context.Context, *LookupRequest) (*LookupResponse, error)
+	AdoptVolume(context.Context, *AdoptVolumeRequest) (*AdoptVolumeResponse, error)
+	GetVolumeAncestry(context.Context, *GetVolumeAncestryRequest) (*GetVolumeAncestryResponse, error)
+	FlattenVolume(context.Context, *FlattenVolumeRequest) (*FlattenVolumeResponse, error)
+	RollbackVolume(context.Context, *RollbackVolumeRequest) (*RollbackVolumeResponse, error)
+	GetReplicationStatus(context.Context, *ReplicationStatusRequest) (*ReplicationStatus, error)
+	PromoteReplica(context.Context, *PromoteReplicaRequest) (*PromoteReplicaResponse, error)
+	ExportSnapshot(context.Context, *ExportSnapshotRequest) (*ExportSnapshotResponse, error)
+	ImportVolume(context.Context, *ImportVolumeRequest) (*ImportVolumeResponse, error)
+	ModifyVolume(context.Context, *ModifyVolumeRequest) (*ModifyVolumeResponse, error)
+}
+
+// extensionHandler makes gRPC method handler out of typed call
+func extensionHandler(method string, newReq func() interface{},
+	call func(ExtensionServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+			interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(ExtensionServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + ExtensionServiceName + "/" + method,
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(ExtensionServer), ctx, req)
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var extensionServiceDesc = grpc.ServiceDesc{
+	ServiceName: ExtensionServiceName,
+	HandlerType: (*ExtensionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		extensionHandler("CreateVolumeGroupSnapshot",
+			func() interface{} { return &CreateVolumeGroupSnapshotRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.CreateVolumeGroupSnapshot(ctx, req.(*CreateVolumeGroupSnapshotRequest))
+			}),
+		extensionHandler("GetVolumeGroupSnapshot",
+			func() interface{} { return &GetVolumeGroupSnapshotRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.GetVolumeGroupSnapshot(ctx, req.(*GetVolumeGroupSnapshotRequest))
+			}),
+		extensionHandler("DeleteVolumeGroupSnapshot",
+			func() interface{} { return &DeleteVolumeGroupSnapshotRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.DeleteVolumeGroupSnapshot(ctx, req.(*DeleteVolumeGroupSnapshotRequest))
+			}),
+		extensionHandler("LookupObjects",
+			func() interface{} { return &LookupRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.LookupObjects(ctx, req.(*LookupRequest))
+			}),
+		extensionHandler("AdoptVolume",
+			func() interface{} { return &AdoptVolumeRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.AdoptVolume(ctx, req.(*AdoptVolumeRequest))
+			}),
+		extensionHandler("GetVolumeAncestry",
+			func() interface{} { return &GetVolumeAncestryRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.GetVolumeAncestry(ctx, req.(*GetVolumeAncestryRequest))
+			}),
+		extensionHandler("FlattenVolume",
+			func() interface{} { return &FlattenVolumeRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.FlattenVolume(ctx, req.(*FlattenVolumeRequest))
+			}),
+		extensionHandler("RollbackVolume",
+			func() interface{} { return &RollbackVolumeRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.RollbackVolume(ctx, req.(*RollbackVolumeRequest))
+			}),
+		extensionHandler("GetReplicationStatus",
+			func() interface{} { return &ReplicationStatusRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.GetReplicationStatus(ctx, req.(*ReplicationStatusRequest))
+			}),
+		extensionHandler("PromoteReplica",
+			func() interface{} { return &PromoteReplicaRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.PromoteReplica(ctx, req.(*PromoteReplicaRequest))
+			}),
+		extensionHandler("ExportSnapshot",
+			func() interface{} { return &ExportSnapshotRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ExportSnapshot(ctx, req.(*ExportSnapshotRequest))
+			}),
+		extensionHandler("ImportVolume",
+			func() interface{} { return &ImportVolumeRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ImportVolume(ctx, req.(*ImportVolumeRequest))
+			}),
+		extensionHandler("ModifyVolume",
+			func() interface{} { return &ModifyVolumeRequest{} },
+			func(s ExtensionServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ModifyVolume(ctx, req.(*ModifyVolumeRequest))
+			}),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "extension.go",
+}
+
+// RegisterExtensionServer registers extension service on gRPC server
+func RegisterExtensionServer(s *grpc.Server, srv ExtensionServer) {
+	s.RegisterService(&extensionServiceDesc, srv)
+}
+
+// ExtensionClient calls extension service
+type ExtensionClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewExtensionClient creates extension service client on top of connection
+func NewExtensionClient(cc *grpc.ClientConn) *ExtensionClient {
+	return &ExtensionClient{cc: cc}
+}
+
+func (c *ExtensionClient) invoke(ctx context.Context, method string, req interface{}, rsp interface{}) error {
+	return c.cc.Invoke(ctx, "/"+ExtensionServiceName+"/"+method, req, rsp,
+		grpc.CallContentSubtype(jsonCodecName))
+}
+
+// CreateVolumeGroupSnapshot snapshots set of volumes at once
+func (c *ExtensionClient) CreateVolumeGroupSnapshot(ctx context.Context, req *CreateVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error) {
+	rsp := &VolumeGroupSnapshot{}
+	if err := c.invoke(ctx, "CreateVolumeGroupSnapshot", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// GetVolumeGroupSnapshot lists members of group snapshot
+func (c *ExtensionClient) GetVolumeGroupSnapshot(ctx context.Context, req *GetVolumeGroupSnapshotRequest) (*VolumeGroupSnapshot, error) {
+	rsp := &VolumeGroupSnapshot{}
+	if err := c.invoke(ctx, "GetVolumeGroupSnapshot", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes all members of group snapshot
+func (c *ExtensionClient) DeleteVolumeGroupSnapshot(ctx context.Context, req *DeleteVolumeGroupSnapshotRequest) (*DeleteVolumeGroupSnapshotResponse, error) {
+	rsp := &DeleteVolumeGroupSnapshotResponse{}
+	if err := c.invoke(ctx, "DeleteVolumeGroupSnapshot", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// LookupObjects finds volumes and snapshots by metadata
+func (c *ExtensionClient) LookupObjects(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	rsp := &LookupResponse{}
+	if err := c.invoke(ctx, "LookupObjects", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// AdoptVolume lets plugin delete static volume
+func (c *ExtensionClient) AdoptVolume(ctx context.Context, req *AdoptVolumeRequest) (*AdoptVolumeResponse, error) {
+	rsp := &AdoptVolumeResponse{}
+	if err := c.invoke(ctx, "AdoptVolume", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// GetVolumeAncestry provides clone chain of volume
+func (c *ExtensionClient) GetVolumeAncestry(ctx context.Context, req *GetVolumeAncestryRequest) (*GetVolumeAncestryResponse, error) {
+	rsp := &GetVolumeAncestryResponse{}
+	if err := c.invoke(ctx, "GetVolumeAncestry", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// FlattenVolume starts or continues making volume independent of its ancestors
+func (c *ExtensionClient) FlattenVolume(ctx context.Context, req *FlattenVolumeRequest) (*FlattenVolumeResponse, error) {
+	rsp := &FlattenVolumeResponse{}
+	if err := c.invoke(ctx, "FlattenVolume", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// RollbackVolume reverts volume to its snapshot
+func (c *ExtensionClient) RollbackVolume(ctx context.Context, req *RollbackVolumeRequest) (*RollbackVolumeResponse, error) {
+	rsp := &RollbackVolumeResponse{}
+	if err := c.invoke(ctx, "RollbackVolume", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// GetReplicationStatus provides replication state of volume
+func (c *ExtensionClient) GetReplicationStatus(ctx context.Context, req *ReplicationStatusRequest) (*ReplicationStatus, error) {
+	rsp := &ReplicationStatus{}
+	if err := c.invoke(ctx, "GetReplicationStatus", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// PromoteReplica makes replica of volume writable for failover
+func (c *ExtensionClient) PromoteReplica(ctx context.Context, req *PromoteReplicaRequest) (*PromoteReplicaResponse, error) {
+	rsp := &PromoteReplicaResponse{}
+	if err := c.invoke(ctx, "PromoteReplica", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// ExportSnapshot stores snapshot data in backup target
+func (c *ExtensionClient) ExportSnapshot(ctx context.Context, req *ExportSnapshotRequest) (*ExportSnapshotResponse, error) {
+	rsp := &ExportSnapshotResponse{}
+	if err := c.invoke(ctx, "ExportSnapshot", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// ImportVolume creates volume out of stream stored in backup target
+func (c *ExtensionClient) ImportVolume(ctx context.Context, req *ImportVolumeRequest) (*ImportVolumeResponse, error) {
+	rsp := &ImportVolumeResponse{}
+	if err := c.invoke(ctx, "ImportVolume", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// ModifyVolume changes IO limits of volume
+func (c *ExtensionClient) ModifyVolume(ctx context.Context, req *ModifyVolumeRequest) (*ModifyVolumeResponse, error) {
+	rsp := &ModifyVolumeResponse{}
+	if err := c.invoke(ctx, "ModifyVolume", req, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
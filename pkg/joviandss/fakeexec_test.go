@@ -0,0 +1,65 @@
+package joviandss
+
+import (
+	"strings"
+	"sync"
+)
+
+// FakeResponse scripted result of a command run by FakeExecutor
+type FakeResponse struct {
+	Out []byte
+	Err error
+}
+
+// FakeExecutor runs no system tools, it records commands
+// and replies with scripted responses instead
+//
+// Allows node side of the plugin to be exercised without storage hardware
+type FakeExecutor struct {
+	mu        sync.Mutex
+	Commands  []string
+	Responses map[string][]FakeResponse // keyed by command line prefix
+}
+
+// NewFakeExecutor creates executor without scripted responses
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		Commands:  make([]string, 0),
+		Responses: make(map[string][]FakeResponse),
+	}
+}
+
+// AddResponse scripts response for commands starting with prefix
+//
+// Responses for the same prefix are returned in order they were added,
+// the last one is repeated
+func (fe *FakeExecutor) AddResponse(prefix string, out string, err error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.Responses[prefix] = append(fe.Responses[prefix], FakeResponse{Out: []byte(out), Err: err})
+}
+
+// Run records command and returns response with the longest matching prefix
+func (fe *FakeExecutor) Run(cmd string, args ...string) ([]byte, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	line := strings.Join(append([]string{cmd}, args...), " ")
+	fe.Commands = append(fe.Commands, line)
+
+	match := ""
+	for p := range fe.Responses {
+		if strings.HasPrefix(line, p) && len(p) > len(match) {
+			match = p
+		}
+	}
+	rsp, ok := fe.Responses[match]
+	if !ok || len(rsp) == 0 {
+		return []byte{}, nil
+	}
+	r := rsp[0]
+	if len(rsp) > 1 {
+		fe.Responses[match] = rsp[1:]
+	}
+	return r.Out, r.Err
+}
@@ -0,0 +1,472 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// retryInterval is a pause between checks of operation in progress
+const retryInterval = 2 * time.Second
+
+// retryAborted repeats fn while operation is in progress or volume is busy
+func retryAborted(fn func() error) error {
+	for {
+		err := fn()
+		if status.Code(err) != codes.Aborted {
+			return err
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// Inspector gives administrative tools access to objects of plugin instance
+// described by controller config. Only Flatten, Rollback, Promote, Import,
+// Modify and CollectGarbage in apply mode modify storage.
+//
+// Volume locks and operations in progress are known only to the controller,
+// so Flatten, Rollback, Promote, Export, Import and Modify are sent to it
+// once inspector is connected, or done by inspector itself once controller
+// is stopped, they fail otherwise.
+type Inspector struct {
+	cp  *ControllerPlugin
+	ext ExtensionServer // serves requests that modify volumes
+}
+
+// VolumeInfo describes volume of plugin instance
+type VolumeInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Concealed bool   `json:"concealed"`
+	Origin    string `json:"origin,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SnapshotInfo describes snapshot of plugin instance
+type SnapshotInfo struct {
+	ID        string   `json:"id"`
+	Volume    string   `json:"volume"`
+	Name      string   `json:"name"`
+	Concealed bool     `json:"concealed"`
+	Clones    []string `json:"clones"`
+	Creation  string   `json:"creation"`
+}
+
+// TargetInfo describes iSCSI target of plugin instance
+type TargetInfo struct {
+	Name     string               `json:"name"`
+	Active   bool                 `json:"active"`
+	Volumes  []string             `json:"volumes"`
+	Users    []string             `json:"users"`
+	Sessions []rest.TargetSession `json:"sessions"`
+}
+
+// GCAction object garbage collection removes or would remove
+type GCAction struct {
+	Volume   string `json:"volume"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Reason   string `json:"reason"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewInspector creates inspector for plugin instance described by config
+func NewInspector(cfg *ControllerCfg, l *logrus.Entry) (*Inspector, error) {
+	cp, err := newControllerPlugin(cfg, l)
+	if err != nil {
+		return nil, err
+	}
+	return &Inspector{cp: cp}, nil
+}
+
+// Connect sends requests that modify volumes to running controller
+// listening on unix socket
+func (in *Inspector) Connect(socket string) error {
+	cc, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	in.ext = NewExtensionClient(cc)
+	return nil
+}
+
+// AssumeStopped lets inspector modify volumes itself,
+// controller of the instance has to be stopped
+func (in *Inspector) AssumeStopped() {
+	in.ext = in.cp
+}
+
+// extension provides service requests that modify volumes are sent to
+func (in *Inspector) extension() (ExtensionServer, error) {
+	if in.ext == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"Volumes are modified by controller, it is neither connected nor stopped")
+	}
+	return in.ext, nil
+}
+
+func (in *Inspector) endpoint() rest.StorageInterface {
+	return *in.cp.endpoints[0]
+}
+
+// splitClones converts list of clones provided by storage
+func splitClones(clones string) []string {
+	out := []string{}
+	for _, c := range strings.Split(clones, ",") {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Volumes lists volumes of plugin instance including concealed ones
+func (in *Inspector) Volumes() ([]VolumeInfo, error) {
+	vnames, rErr := in.endpoint().ListVolumes()
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	out := []VolumeInfo{}
+	for _, vname := range vnames {
+		if !in.cp.ownsVolume(vname) {
+			continue
+		}
+		v, err := in.cp.getVolume(vname)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		info := VolumeInfo{
+			ID:        vname,
+			Concealed: strings.HasPrefix(vname, "c_"),
+		}
+		info.Size, _ = strconv.ParseInt(v.Volsize, 10, 64)
+		if v.IsClone {
+			info.Origin = v.Origin
+		}
+
+		rec, err := in.cp.meta.GetVolume(vname)
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			info.Name = rec.Name
+			info.Owner = rec.Owner
+			info.Namespace = rec.Namespace
+		}
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Snapshots lists snapshots of plugin instance including concealed ones
+func (in *Inspector) Snapshots() ([]SnapshotInfo, error) {
+	filter := func(s string) bool {
+		return in.cp.ownsSnapshot(s)
+	}
+
+	snaps, rErr := in.endpoint().ListAllSnapshots(filter)
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	out := []SnapshotInfo{}
+	for _, s := range snaps {
+		info := SnapshotInfo{
+			ID:        s.Name,
+			Volume:    s.Volume,
+			Concealed: strings.HasPrefix(s.Name, "c_"),
+			Clones:    splitClones(s.Clones),
+			Creation:  s.Properties.Creation,
+		}
+		if !info.Concealed {
+			props, rErr := in.endpoint().GetSnapshotProperties(s.Volume, s.Name)
+			if rErr == nil {
+				info.Name = props[propName]
+			}
+		}
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Ancestry lists snapshots volume is cloned from, nearest first
+func (in *Inspector) Ancestry(vID string) ([]VolumeAncestor, error) {
+	return in.cp.getAncestry(vID)
+}
+
+// Flatten starts or continues making volume independent of its ancestors
+func (in *Inspector) Flatten(vID string) (*FlattenVolumeResponse, error) {
+	ext, err := in.extension()
+	if err != nil {
+		return nil, err
+	}
+	return ext.FlattenVolume(context.Background(), &FlattenVolumeRequest{VolumeID: vID})
+}
+
+// Rollback reverts volume to its snapshot, waiting until rollback is done
+func (in *Inspector) Rollback(vID string, sID string, force bool) error {
+	ext, err := in.extension()
+	if err != nil {
+		return err
+	}
+	req := &RollbackVolumeRequest{VolumeID: vID, SnapshotID: sID, Force: force}
+	return retryAborted(func() error {
+		_, err := ext.RollbackVolume(context.Background(), req)
+		return err
+	})
+}
+
+// Replication lists replication state of volumes that have it enabled
+func (in *Inspector) Replication() ([]ReplicationStatus, error) {
+	vols, err := in.Volumes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []ReplicationStatus{}
+	now := time.Now()
+	for _, v := range vols {
+		if v.Concealed || isNFSVolume(v.ID) {
+			continue
+		}
+		rs, err := in.cp.replicationStatus(v.ID, now)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, err
+		}
+		if rs.State != ReplicationDisabled {
+			out = append(out, *rs)
+		}
+	}
+	return out, nil
+}
+
+// Promote makes replica of volume on secondary storage writable
+func (in *Inspector) Promote(vID string) error {
+	ext, err := in.extension()
+	if err != nil {
+		return err
+	}
+	_, err = ext.PromoteReplica(context.Background(), &PromoteReplicaRequest{VolumeID: vID})
+	return err
+}
+
+// Export stores snapshot data in backup target, waiting until it is stored
+func (in *Inspector) Export(sID string, target string, name string) (*ExportSnapshotResponse, error) {
+	ext, err := in.extension()
+	if err != nil {
+		return nil, err
+	}
+	req := &ExportSnapshotRequest{SnapshotID: sID, Target: target, Name: name}
+	var rsp *ExportSnapshotResponse
+	err = retryAborted(func() (err error) {
+		rsp, err = ext.ExportSnapshot(context.Background(), req)
+		return err
+	})
+	return rsp, err
+}
+
+// Import creates volume out of stream in backup target, waiting until it is done
+func (in *Inspector) Import(name string, source string, stream string) (*ImportVolumeResponse, error) {
+	ext, err := in.extension()
+	if err != nil {
+		return nil, err
+	}
+	req := &ImportVolumeRequest{Name: name, Source: source, Stream: stream}
+	var rsp *ImportVolumeResponse
+	err = retryAborted(func() (err error) {
+		rsp, err = ext.ImportVolume(context.Background(), req)
+		return err
+	})
+	return rsp, err
+}
+
+// Modify changes IO limits of volume
+func (in *Inspector) Modify(vID string, params map[string]string) error {
+	ext, err := in.extension()
+	if err != nil {
+		return err
+	}
+	_, err = ext.ModifyVolume(context.Background(), &ModifyVolumeRequest{VolumeID: vID, Parameters: params})
+	return err
+}
+
+// Targets lists iSCSI targets of plugin instance with their sessions
+func (in *Inspector) Targets() ([]TargetInfo, error) {
+	targets, rErr := in.endpoint().ListTargets()
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	prefix := strings.ToLower(in.cp.iqn + ":")
+	out := []TargetInfo{}
+	for _, t := range targets {
+		if !strings.HasPrefix(strings.ToLower(t.Name), prefix) {
+			continue
+		}
+		info := TargetInfo{
+			Name:     t.Name,
+			Active:   t.Active,
+			Volumes:  []string{},
+			Users:    []string{},
+			Sessions: []rest.TargetSession{},
+		}
+
+		luns, rErr := in.endpoint().GetTargetLuns(t.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		for _, lun := range luns {
+			info.Volumes = append(info.Volumes, lun.Name)
+		}
+
+		users, rErr := in.endpoint().GetTargetUsers(t.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		for _, u := range users {
+			info.Users = append(info.Users, u.Name)
+		}
+
+		sessions, rErr := in.endpoint().GetTargetSessions(t.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		info.Sessions = append(info.Sessions, sessions...)
+
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// CollectGarbage finds concealed objects that are no longer needed
+//
+// Concealed volume is garbage once none of its snapshots has clones,
+// concealed snapshot of public volume is garbage if it has no clones.
+// In apply mode objects are deleted, along with concealed parents of
+// deleted volumes. Clones are checked again right before each deletion,
+// controller should still be stopped, clone it is about to make is not
+// visible on storage yet.
+func (in *Inspector) CollectGarbage(apply bool) ([]GCAction, error) {
+	snaps, err := in.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	// Concealed volumes that still have clones are in use
+	inUse := map[string]bool{}
+	for _, s := range snaps {
+		if len(s.Clones) > 0 {
+			inUse[s.Volume] = true
+		}
+	}
+
+	vols, err := in.Volumes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []GCAction{}
+	for _, v := range vols {
+		if !v.Concealed || inUse[v.ID] {
+			continue
+		}
+		out = append(out, GCAction{
+			Volume: v.ID,
+			Reason: "concealed volume has no clones",
+		})
+	}
+
+	for _, s := range snaps {
+		if !s.Concealed || len(s.Clones) > 0 || strings.HasPrefix(s.Volume, "c_") {
+			continue
+		}
+		// Source of volume copy made by flatten
+		if s.ID == flattenSnapshotName(s.Volume) {
+			continue
+		}
+		// Base of incremental replication
+		if isReplicationSnapshot(s.ID) {
+			continue
+		}
+		// Source of full copy that is not done yet
+		if isCopySnapshot(s.Volume, s.ID) {
+			running, err := in.cp.copyRunning(copyDestination(s.Volume, s.ID))
+			if err != nil {
+				return nil, err
+			}
+			if running {
+				continue
+			}
+		}
+		out = append(out, GCAction{
+			Volume:   s.Volume,
+			Snapshot: s.ID,
+			Reason:   "concealed snapshot has no clones",
+		})
+	}
+
+	if !apply {
+		return out, nil
+	}
+
+	for i := range out {
+		a := &out[i]
+		if len(a.Snapshot) == 0 {
+			err = in.cp.gcVolume(a.Volume)
+		} else {
+			err = in.gcSnapshot(a.Volume, a.Snapshot)
+		}
+
+		if err != nil {
+			a.Error = err.Error()
+			err = nil
+			continue
+		}
+		a.Done = true
+	}
+	return out, nil
+}
+
+// gcSnapshot deletes concealed snapshot unless it got clones since listing
+func (in *Inspector) gcSnapshot(vID string, sID string) error {
+	s, rErr := in.endpoint().GetSnapshot(vID, sID)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil
+		}
+		return fmt.Errorf("%s", rErr.Error())
+	}
+	if clones := splitClones(s.Clones); len(clones) > 0 {
+		return fmt.Errorf("Snapshot got clones %s, kept", strings.Join(clones, ","))
+	}
+
+	rErr = in.endpoint().DeleteSnapshot(vID, sID)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		return fmt.Errorf("%s", rErr.Error())
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package joviandss
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// modifyRecorder is controller that only records ModifyVolume requests
+type modifyRecorder struct {
+	ExtensionServer
+	requests []ModifyVolumeRequest
+}
+
+func (m *modifyRecorder) ModifyVolume(ctx context.Context, req *ModifyVolumeRequest) (*ModifyVolumeResponse, error) {
+	m.requests = append(m.requests, *req)
+	return &ModifyVolumeResponse{}, nil
+}
+
+func TestInspectorNotConnected(t *testing.T) {
+	in := &Inspector{}
+	err := in.Modify("vol", map[string]string{paramReadIOPS: "100"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestInspectorConnected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "csi.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := &modifyRecorder{}
+	srv := grpc.NewServer()
+	RegisterExtensionServer(srv, controller)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	in := &Inspector{}
+	if err = in.Connect(socket); err != nil {
+		t.Fatal(err)
+	}
+	if err = in.Modify("vol", map[string]string{paramReadIOPS: "100"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(controller.requests) != 1 || controller.requests[0].VolumeID != "vol" ||
+		controller.requests[0].Parameters[paramReadIOPS] != "100" {
+		t.Fatalf("unexpected requests %+v", controller.requests)
+	}
+}
@@ -0,0 +1,75 @@
+package joviandss
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Several driver instances might share the same pool.
+// Ids of volumes created by an instance start with the instance name,
+// so every instance works only with its own volumes and snapshots.
+// Volumes of default instance, with empty name, have no prefix.
+
+const (
+	maxInstanceLen = 32
+	volumeHashLen  = 64
+)
+
+var (
+	instanceRe   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	volumeHashRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// validateInstance checks that instance name can be a part of volume id
+func validateInstance(instance string) error {
+	if len(instance) == 0 {
+		return nil
+	}
+	if len(instance) > maxInstanceLen || !instanceRe.MatchString(instance) {
+		return fmt.Errorf("Instance name %s is incorrect, "+
+			"it should be up to %d lowercase letters, digits and dashes",
+			instance, maxInstanceLen)
+	}
+	return nil
+}
+
+// instanceIqn makes target name prefix unique for instance
+func instanceIqn(prefix string, instance string) string {
+	if len(instance) == 0 {
+		return prefix
+	}
+	return prefix + "." + instance
+}
+
+// volumeID returns id of volume with given CSI name
+func (cp *ControllerPlugin) volumeID(name string) string {
+	return cp.instancePrefix() + cp.getStandardId(cp.cfg.Salt, name)
+}
+
+func (cp *ControllerPlugin) instancePrefix() string {
+	if len(cp.instance) == 0 {
+		return ""
+	}
+	return cp.instance + "-"
+}
+
+// ownsVolume checks if volume was created by this instance
+//
+// Concealed volumes and NFS datasets belong to instance of their base volume
+func (cp *ControllerPlugin) ownsVolume(vID string) bool {
+	vID = strings.TrimPrefix(vID, "c_")
+	vID = strings.TrimPrefix(vID, nfsVolumePrefix)
+
+	prefix := cp.instancePrefix()
+	if len(vID) != len(prefix)+volumeHashLen || !strings.HasPrefix(vID, prefix) {
+		return false
+	}
+	return volumeHashRe.MatchString(vID[len(prefix):])
+}
+
+// ownsSnapshot checks if snapshot was created by this instance
+func (cp *ControllerPlugin) ownsSnapshot(sname string) bool {
+	snameT := strings.Split(strings.TrimPrefix(sname, "c_"), "_")
+	return len(snameT) == 2 && cp.ownsVolume(snameT[0])
+}
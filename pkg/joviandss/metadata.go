@@ -0,0 +1,442 @@
+package joviandss
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// propPrefix namespace of ZFS user properties set by plugin
+	propPrefix = "org.open-e.csi:"
+
+	propName       = propPrefix + "name"
+	propID         = propPrefix + "id"
+	propSource     = propPrefix + "source"
+	propGroup      = propPrefix + "group"
+	propParameters = propPrefix + "parameters"
+	propInstance   = propPrefix + "instance"
+	propCreated    = propPrefix + "created"
+	propAdopted    = propPrefix + "adopted"
+	propPolicy     = propPrefix + "policy"
+	propReplicated = propPrefix + "replicated"
+	propEncryption = propPrefix + "encryption-key"
+
+	propPVCName      = propPrefix + "pvc-name"
+	propPVCNamespace = propPrefix + "pvc-namespace"
+	propPVName       = propPrefix + "pv-name"
+
+	propVSName        = propPrefix + "volumesnapshot-name"
+	propVSNamespace   = propPrefix + "volumesnapshot-namespace"
+	propVSContentName = propPrefix + "volumesnapshotcontent-name"
+
+	legacySnapshotRegister = "SnapshotRegister"
+)
+
+// Parameters added by external provisioner and snapshotter
+// when started with --extra-create-metadata
+const (
+	extraMetadataPrefix = "csi.storage.k8s.io/"
+
+	paramPVCName      = extraMetadataPrefix + "pvc/name"
+	paramPVCNamespace = extraMetadataPrefix + "pvc/namespace"
+	paramPVName       = extraMetadataPrefix + "pv/name"
+
+	paramVSName        = extraMetadataPrefix + "volumesnapshot/name"
+	paramVSNamespace   = extraMetadataPrefix + "volumesnapshot/namespace"
+	paramVSContentName = extraMetadataPrefix + "volumesnapshotcontent/name"
+)
+
+// Ownership describes kubernetes objects storage object belongs to
+//
+// For volumes Owner is PVC and Content is PV,
+// for snapshots Owner is VolumeSnapshot and Content is VolumeSnapshotContent
+type Ownership struct {
+	Owner     string
+	Namespace string
+	Content   string
+	Instance  string // driver instance that created the object
+	Created   time.Time
+}
+
+// volumeOwnership extracts ownership from CreateVolume parameters
+func volumeOwnership(params map[string]string, instance string) Ownership {
+	return Ownership{
+		Owner:     params[paramPVCName],
+		Namespace: params[paramPVCNamespace],
+		Content:   params[paramPVName],
+		Instance:  instance,
+		Created:   time.Now().UTC(),
+	}
+}
+
+// snapshotOwnership extracts ownership from CreateSnapshot parameters
+func snapshotOwnership(params map[string]string, instance string) Ownership {
+	return Ownership{
+		Owner:     params[paramVSName],
+		Namespace: params[paramVSNamespace],
+		Content:   params[paramVSContentName],
+		Instance:  instance,
+		Created:   time.Now().UTC(),
+	}
+}
+
+// storageParameters returns parameters without ones added by sidecars
+func storageParameters(params map[string]string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range params {
+		if !strings.HasPrefix(k, extraMetadataPrefix) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// SnapshotRecord metadata of snapshot created by plugin
+type SnapshotRecord struct {
+	ID           string // snapshot id, <volume>_<hash of name>
+	Name         string // CSI name of the snapshot
+	SourceVolume string
+	Group        string // id of group snapshot snapshot belongs to
+	Policy       string // label of snapshot policy rule that created snapshot
+	Ownership
+}
+
+// VolumeRecord metadata of volume created by plugin
+type VolumeRecord struct {
+	ID            string
+	Name          string // CSI name of the volume
+	Parameters    map[string]string
+	Adopted       bool   // static volume plugin is allowed to delete
+	EncryptionKey string // reference to key of encrypted volume, <mode>:<key id>
+	Ownership
+}
+
+// MetadataStore keeps plugin metadata about storage objects
+type MetadataStore interface {
+	// sID is a hash part of snapshot id
+	PutSnapshot(sID string, rec SnapshotRecord) error
+	GetSnapshot(sID string) (*SnapshotRecord, error)
+	DeleteSnapshot(sID string) error
+
+	PutVolume(rec VolumeRecord) error
+	// GetVolume returns nil if volume does not exist or has no record
+	GetVolume(vID string) (*VolumeRecord, error)
+
+	// Find methods look for records with property key equal to value,
+	// key is a property name without namespace, like pvc-name
+	FindVolumes(key string, value string) ([]VolumeRecord, error)
+	FindSnapshots(key string, value string) ([]SnapshotRecord, error)
+}
+
+// ownershipProps makes properties out of ownership
+func ownershipProps(o Ownership, owner string, namespace string, content string) map[string]string {
+	props := map[string]string{
+		owner:        o.Owner,
+		namespace:    o.Namespace,
+		content:      o.Content,
+		propInstance: o.Instance,
+	}
+	if !o.Created.IsZero() {
+		props[propCreated] = o.Created.Format(time.RFC3339)
+	}
+	return props
+}
+
+// ownershipFromProps restores ownership from properties
+func ownershipFromProps(props map[string]string, owner string, namespace string, content string) Ownership {
+	o := Ownership{
+		Owner:     props[owner],
+		Namespace: props[namespace],
+		Content:   props[content],
+		Instance:  props[propInstance],
+	}
+	if c, err := time.Parse(time.RFC3339, props[propCreated]); err == nil {
+		o.Created = c
+	}
+	return o
+}
+
+func snapshotFromProps(sname string, vname string, props map[string]string) *SnapshotRecord {
+	return &SnapshotRecord{
+		ID:           sname,
+		Name:         props[propName],
+		SourceVolume: vname,
+		Group:        props[propGroup],
+		Policy:       props[propPolicy],
+		Ownership:    ownershipFromProps(props, propVSName, propVSNamespace, propVSContentName),
+	}
+}
+
+func volumeFromProps(l *logrus.Entry, vID string, props map[string]string) *VolumeRecord {
+	rec := &VolumeRecord{
+		ID:            vID,
+		Name:          props[propName],
+		Parameters:    make(map[string]string),
+		Adopted:       props[propAdopted] == "true",
+		EncryptionKey: props[propEncryption],
+		Ownership:     ownershipFromProps(props, propPVCName, propPVCNamespace, propPVName),
+	}
+	if p := props[propParameters]; len(p) > 0 {
+		if err := json.Unmarshal([]byte(p), &rec.Parameters); err != nil {
+			l.Warnf("Unable to parse parameters of volume %s: %s", vID, err.Error())
+		}
+	}
+	return rec
+}
+
+// propertyStore keeps metadata as ZFS user properties of the objects it describes,
+// so metadata is removed together with the object
+type propertyStore struct {
+	l        *logrus.Entry
+	endpoint *rest.StorageInterface
+	owns     func(vID string) bool
+}
+
+// newPropertyStore creates metadata store on top of storage endpoint
+//
+// Store ignores volumes for which owns returns false, and their snapshots
+func newPropertyStore(l *logrus.Entry, endpoint *rest.StorageInterface,
+	owns func(vID string) bool) *propertyStore {
+	return &propertyStore{
+		l:        l.WithField("obj", "MetadataStore"),
+		endpoint: endpoint,
+		owns:     owns,
+	}
+}
+
+// ownsSnapshot checks that snapshot name has form <owned volume>_<id>
+func (ps *propertyStore) ownsSnapshot(sname string) bool {
+	snameT := strings.Split(sname, "_")
+	return len(snameT) == 2 && ps.owns(snameT[0])
+}
+
+// findSnapshot looks for snapshot with given hash part of id on any volume
+func (ps *propertyStore) findSnapshot(sID string) (*rest.SnapshotShort, error) {
+	suffix := "_" + sID
+	filter := func(s string) bool {
+		return strings.HasSuffix(s, suffix) && ps.ownsSnapshot(s)
+	}
+
+	snaps, rErr := (*ps.endpoint).ListAllSnapshots(filter)
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+	return &snaps[0], nil
+}
+
+func (ps *propertyStore) PutSnapshot(sID string, rec SnapshotRecord) error {
+	props := ownershipProps(rec.Ownership, propVSName, propVSNamespace, propVSContentName)
+	props[propID] = rec.ID
+	props[propName] = rec.Name
+	props[propSource] = rec.SourceVolume
+	props[propGroup] = rec.Group
+	props[propPolicy] = rec.Policy
+
+	rErr := (*ps.endpoint).SetSnapshotProperties(rec.SourceVolume, rec.ID, props)
+	if rErr != nil {
+		msg := fmt.Sprintf("Unable to store metadata of snapshot %s: %s", rec.ID, rErr.Error())
+		ps.l.Warn(msg)
+		return status.Error(codes.Internal, msg)
+	}
+	return nil
+}
+
+func (ps *propertyStore) GetSnapshot(sID string) (*SnapshotRecord, error) {
+	s, err := ps.findSnapshot(sID)
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	vname := strings.TrimSuffix(s.Name, "_"+sID)
+
+	props, rErr := (*ps.endpoint).GetSnapshotProperties(vname, s.Name)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+	return snapshotFromProps(s.Name, vname, props), nil
+}
+
+func (ps *propertyStore) DeleteSnapshot(sID string) error {
+	// Properties are removed together with snapshot
+	return nil
+}
+
+func (ps *propertyStore) PutVolume(rec VolumeRecord) error {
+	params, err := json.Marshal(rec.Parameters)
+	if err != nil {
+		return status.Errorf(codes.Internal, err.Error())
+	}
+
+	props := ownershipProps(rec.Ownership, propPVCName, propPVCNamespace, propPVName)
+	props[propName] = rec.Name
+	props[propParameters] = string(params)
+	if rec.Adopted {
+		props[propAdopted] = "true"
+	}
+	if len(rec.EncryptionKey) > 0 {
+		props[propEncryption] = rec.EncryptionKey
+	}
+
+	rErr := (*ps.endpoint).SetVolumeProperties(rec.ID, props)
+	if rErr != nil {
+		msg := fmt.Sprintf("Unable to store metadata of volume %s: %s", rec.ID, rErr.Error())
+		ps.l.Warn(msg)
+		return status.Error(codes.Internal, msg)
+	}
+	return nil
+}
+
+func (ps *propertyStore) GetVolume(vID string) (*VolumeRecord, error) {
+	props, rErr := (*ps.endpoint).GetVolumeProperties(vID)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	// Parameters are written with every record, empty ones too,
+	// volume without them has no record
+	if _, ok := props[propParameters]; !ok {
+		return nil, nil
+	}
+	return volumeFromProps(ps.l, vID, props), nil
+}
+
+func (ps *propertyStore) FindVolumes(key string, value string) ([]VolumeRecord, error) {
+	vnames, rErr := (*ps.endpoint).ListVolumes()
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	out := []VolumeRecord{}
+	for _, vname := range vnames {
+		if !ps.owns(vname) || strings.HasPrefix(vname, "c_") {
+			continue
+		}
+		props, rErr := (*ps.endpoint).GetVolumeProperties(vname)
+		if rErr != nil {
+			// Volume might be deleted in between
+			if rErr.GetCode() == rest.RestResourceDNE {
+				continue
+			}
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		if v, ok := props[propPrefix+key]; ok && v == value {
+			out = append(out, *volumeFromProps(ps.l, vname, props))
+		}
+	}
+	return out, nil
+}
+
+func (ps *propertyStore) FindSnapshots(key string, value string) ([]SnapshotRecord, error) {
+	filter := func(s string) bool {
+		return ps.ownsSnapshot(s)
+	}
+
+	snaps, rErr := (*ps.endpoint).ListAllSnapshots(filter)
+	if rErr != nil {
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	out := []SnapshotRecord{}
+	for _, s := range snaps {
+		vname := strings.Split(s.Name, "_")[0]
+		props, rErr := (*ps.endpoint).GetSnapshotProperties(vname, s.Name)
+		if rErr != nil {
+			if rErr.GetCode() == rest.RestResourceDNE {
+				continue
+			}
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		if v, ok := props[propPrefix+key]; ok && v == value {
+			out = append(out, *snapshotFromProps(s.Name, vname, props))
+		}
+	}
+	return out, nil
+}
+
+// migrateSnapshotRegister moves records from snapshot register volume
+// used by older plugin versions to metadata store and removes register
+//
+// Register only holds hash part of snapshot ids, so CSI names remain unknown.
+// It is deleted only once every record is stored, otherwise it is kept
+// and migration is repeated on next start.
+func migrateSnapshotRegister(l *logrus.Entry, endpoint *rest.StorageInterface,
+	meta MetadataStore, register string) error {
+
+	if _, rErr := (*endpoint).GetVolume(register); rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil
+		}
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	l.Infof("Migrating snapshot register %s", register)
+
+	records, rErr := (*endpoint).ListVolumeSnapshots(register, nil)
+	if rErr != nil {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	ps, ok := meta.(*propertyStore)
+	if !ok {
+		l.Warnf("Metadata store can not look up snapshots, register %s with %d records is kept",
+			register, len(records))
+		return nil
+	}
+
+	failed := []string{}
+	for _, r := range records {
+		sID := r.Name
+		s, err := ps.findSnapshot(sID)
+		if err == nil && s == nil {
+			l.Debugf("Snapshot %s of register no longer exists", sID)
+			continue
+		}
+		if err == nil {
+			rec := SnapshotRecord{
+				ID:           s.Name,
+				SourceVolume: strings.TrimSuffix(s.Name, "_"+sID),
+			}
+			err = meta.PutSnapshot(sID, rec)
+		}
+		if err != nil {
+			l.Warnf("Unable to migrate record of snapshot %s: %s", sID, err)
+			failed = append(failed, sID)
+		}
+	}
+	if len(failed) > 0 {
+		l.Warnf("Snapshot register %s is kept, records not migrated: %s",
+			register, strings.Join(failed, ", "))
+		return status.Errorf(codes.Internal, "Unable to migrate %d records of snapshot register %s",
+			len(failed), register)
+	}
+
+	for _, r := range records {
+		rErr = (*endpoint).DeleteSnapshot(register, r.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	rErr = (*endpoint).DeleteVolume(register, false)
+	if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+	l.Infof("Snapshot register %s migrated, %d records", register, len(records))
+	return nil
+}
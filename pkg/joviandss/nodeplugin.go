@@ -21,6 +21,9 @@ var supportedNodeServiceCapabilities = []csi.NodeServiceCapability_RPC_Type{
 type NodePlugin struct {
 	cfg *NodeCfg
 	l   *logrus.Entry
+
+	// reconciled is closed once staged volumes are reconciled
+	reconciled chan struct{}
 }
 
 func GetNodePlugin(conf *NodeCfg, log *logrus.Entry) (np *NodePlugin, err error) {
@@ -30,13 +33,36 @@ func GetNodePlugin(conf *NodeCfg, log *logrus.Entry) (np *NodePlugin, err error)
 		"plugin": "Node",
 	}
 	np = &NodePlugin{
-		cfg: conf,
-		l:   log.WithFields(lFields),
+		cfg:        conf,
+		l:          log.WithFields(lFields),
+		reconciled: make(chan struct{}),
 	}
-	log.Debug(fmt.Sprintf("Config: %+v", *conf))
+	c := *conf
+	c.ChapSecret = strippedValue
+	log.Debug(fmt.Sprintf("Config: %+v", c))
+
+	// Restoring sessions takes a while, node registers meanwhile
+	// and failure to reconcile should not prevent it from serving requests
+	go func() {
+		defer close(np.reconciled)
+		if _, err := np.Reconcile(); err != nil {
+			np.l.Warnf("Unable to reconcile staged volumes: %s", err.Error())
+		}
+	}()
 	return np, nil
 }
 
+// checkReconciled refuses staging until reconciliation is over,
+// so sessions being set up are not taken for orphans
+func (np *NodePlugin) checkReconciled() error {
+	select {
+	case <-np.reconciled:
+		return nil
+	default:
+		return status.Error(codes.Aborted, "Staged volumes are being reconciled")
+	}
+}
+
 func (np *NodePlugin) NodeExpandVolume(ctx context.Context, in *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	np.l.Trace("Expanding Volume")
 	out := new(csi.NodeExpandVolumeResponse)
@@ -47,7 +73,7 @@ func (np *NodePlugin) NodeGetInfo(
 	ctx context.Context,
 	req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 
-	np.l.Tracef("NodeGetInfo: %+v", req)
+	np.l.Tracef("NodeGetInfo: %s", stripSecrets(req))
 
 	//TODO: Add node identification
 	return &csi.NodeGetInfoResponse{
@@ -55,11 +81,64 @@ func (np *NodePlugin) NodeGetInfo(
 	}, nil
 }
 
+// publishNFS mounts NFS share of the volume to target path
+func (np *NodePlugin) publishNFS(req *csi.NodePublishVolumeRequest) error {
+	var msg string
+	ctx := req.GetPublishContext()
+	tp := req.GetTargetPath()
+	if len(tp) == 0 {
+		msg = fmt.Sprintf("Request do not contain TargetPath.")
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	if len(ctx["addr"]) == 0 || len(ctx["export"]) == 0 {
+		msg = fmt.Sprintf("Context do not contain NFS share address")
+		return status.Error(codes.InvalidArgument, msg)
+	}
+
+	m := mount.New("")
+	if err := os.MkdirAll(tp, 0750); err != nil {
+		msg = fmt.Sprintf("Unable to create directory %s, Error:%s", tp, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	notMnt, err := m.IsLikelyNotMountPoint(tp)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to check mount point %s, Error:%s", tp, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	if notMnt == false {
+		np.l.Tracef("Volume %s is already mounted to %s", req.GetVolumeId(), tp)
+		return nil
+	}
+
+	mOpt := append([]string{}, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+	if req.GetReadonly() || ctx["readonly"] == "true" ||
+		isReadOnlyMode(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+		mOpt = append(mOpt, "ro")
+	}
+
+	source := ctx["addr"] + ":" + ctx["export"]
+	if err = m.Mount(source, tp, "nfs", mOpt); err != nil {
+		msg = fmt.Sprintf("Unable to mount %s to %s, Err: %s", source, tp, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	return nil
+}
+
 func (np *NodePlugin) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 
 	np.l.Tracef("Node Stage Volume")
 	var msg string
 
+	// NFS shares are mounted directly on publish
+	if req.GetPublishContext()["protocol"] == ProtocolNFS {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := np.checkReconciled(); err != nil {
+		return nil, err
+	}
+
 	t, err := GetTargetFromReq(np.cfg, np.l, *req)
 	if err != nil {
 		return nil, err
@@ -80,7 +159,13 @@ func (np *NodePlugin) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 	}
 
-	// Volume do not exist
+	// Volume might be already staged, so cleanup is done only for new sessions
+	staged, err := t.IsAttached()
+	if err != nil {
+		np.l.Warn(err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	err = t.SerializeTarget()
 	if err != nil {
 		return nil, err
@@ -89,11 +174,30 @@ func (np *NodePlugin) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	err = t.StageVolume()
 
 	if err != nil {
-		t.DeleteSerialization()
+		if !staged {
+			t.DeleteSerialization()
+		}
 		msg = fmt.Sprintf("Unable to stage volume: %s ", err.Error())
 		np.l.Warn(msg)
 		return nil, status.Error(codes.Internal, msg)
 	}
+
+	// Device path might be identified only after attachment
+	if err = t.SerializeTarget(); err != nil {
+		return nil, err
+	}
+
+	// File system is created and mounted once per node on staging path
+	if req.GetVolumeCapability().GetBlock() == nil {
+		if err = t.FormatMountStaged(); err != nil {
+			if !staged {
+				t.UnStageVolume()
+				t.DeleteSerialization()
+			}
+			np.l.Warnf("Unable to mount staged volume: %s", err.Error())
+			return nil, err
+		}
+	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -109,6 +213,10 @@ func (np *NodePlugin) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
+	if err := np.checkReconciled(); err != nil {
+		return nil, err
+	}
+
 	stp := req.GetStagingTargetPath()
 	if len(stp) == 0 {
 		msg = fmt.Sprintf("Request do not contain staging target path")
@@ -116,14 +224,33 @@ func (np *NodePlugin) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
-	if GetStageStatus(stp) == false {
+	var t *Target
+	var err error
+	if GetStageStatus(stp) {
+		t, err = GetTargetFromPath(np.cfg, np.l, stp)
+	}
+	if t == nil {
+		// Record is lost, try to recover target from active session
+		if err != nil {
+			np.l.Warnf("Unable to get info about target: %s", err.Error())
+		}
+		t, err = GetTargetFromSession(np.cfg, np.l, stp, vname)
+		if err != nil {
+			msg = fmt.Sprintf("Unable to recover target of volume %s: %s", vname, err.Error())
+			np.l.Warn(msg)
+			return nil, status.Error(codes.Internal, msg)
+		}
+	}
+	if t == nil {
+		// Nothing is attached, only leftover mount might remain
+		t, _ = GetTarget(np.cfg, np.l, "")
+		t.STPath = stp
+		if err = t.UnMountStaged(); err != nil {
+			return nil, err
+		}
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
-	t, err := GetTargetFromPath(np.cfg, np.l, stp)
-	// TODO: implement recovery using target path
-	if err != nil {
-		msg = fmt.Sprintf("Unable to get info about target: %s", err.Error())
-		np.l.Warn(msg)
+	if err = t.UnMountStaged(); err != nil {
 		return nil, err
 	}
 	err = t.UnStageVolume()
@@ -140,18 +267,54 @@ func (np *NodePlugin) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	np.l.Tracef("Node Publish Volume %s", req.GetVolumeId())
 
-	block := false
+	block := req.GetVolumeCapability().GetBlock() != nil
 	var msg string
 
-	t, err := GetTargetFromReq(np.cfg, np.l, *req)
+	if req.GetPublishContext()["protocol"] == ProtocolNFS {
+		if err := np.publishNFS(req); err != nil {
+			np.l.Warnf("Unable to mount NFS volume: %s", err.Error())
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	var t *Target
+	var err error
+
+	// Staged record holds file system and mount options selected on stage
+	if GetStageStatus(req.GetStagingTargetPath()) {
+		t, err = GetTargetFromPath(np.cfg, np.l, req.GetStagingTargetPath())
+		if err == nil {
+			t.TPath = req.GetTargetPath()
+		}
+	}
+	if t == nil {
+		// Corrupted record is quarantined, so request data is used instead
+		t, err = GetTargetFromReq(np.cfg, np.l, *req)
+		if err == nil && t.STPath != "" {
+			t.SerializeTarget()
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	if len(t.TPath) == 0 {
+		msg = fmt.Sprintf("Request do not contain TargetPath.")
+		np.l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
 
+	ro := req.GetReadonly() || isReadOnlyMode(req.GetVolumeCapability().GetAccessMode().GetMode())
 	if !block {
-		err = t.FormatMountVolume(req)
+		// Volumes staged by older versions are not mounted on staging path
+		if err = t.MigrateSerialization(); err == nil {
+			err = t.FormatMountStaged()
+		}
+		if err == nil {
+			err = t.MountVolume(ro)
+		}
 	} else {
-		return nil, status.Error(codes.Unimplemented, "Block attaching is not supported")
+		err = t.MountBlockDevice(ro)
 	}
 
 	if err != nil {
@@ -167,8 +330,6 @@ func (np *NodePlugin) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 
 	np.l.Tracef("Node Unpublish Volume %s", req.GetVolumeId())
 
-	block := false
-	//eq := false
 	var msg string
 
 	tp := req.GetTargetPath()
@@ -183,10 +344,9 @@ func (np *NodePlugin) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, err
 	}
 
-	if !block {
-		err = t.UnMountVolume()
-	} else {
-		return nil, status.Error(codes.Unimplemented, "Block detaching is not supported")
+	// Block devices are bind mounted same as file systems
+	if err = t.UnMountVolume(); err != nil {
+		return nil, err
 	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
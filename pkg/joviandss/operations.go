@@ -0,0 +1,145 @@
+package joviandss
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Long tasks run in background, so RPCs return before sidecar timeouts.
+// Operations are keyed by RPC and request name, retried RPC reattaches
+// to operation in flight instead of starting it once again.
+// Result of finished operation is kept until retry collects it.
+
+const (
+	// operationWait is the longest time RPC waits for its operation
+	operationWait = 5 * time.Second
+	// operationTTL is the time result of operation is kept for retry
+	operationTTL = 10 * time.Minute
+)
+
+// operation is a task running in background
+type operation struct {
+	key      string
+	finished time.Time
+	done     chan struct{}
+
+	access   sync.Mutex
+	progress int
+	err      error
+}
+
+// setProgress reports percent of work done
+func (op *operation) setProgress(p int) {
+	op.access.Lock()
+	op.progress = p
+	op.access.Unlock()
+}
+
+// Progress returns percent of work done
+func (op *operation) Progress() int {
+	op.access.Lock()
+	defer op.access.Unlock()
+	return op.progress
+}
+
+// Err returns result of finished operation
+func (op *operation) Err() error {
+	op.access.Lock()
+	defer op.access.Unlock()
+	return op.err
+}
+
+// Done checks if operation is finished
+func (op *operation) Done() bool {
+	select {
+	case <-op.done:
+		return true
+	default:
+		return false
+	}
+}
+
+type operationTracker struct {
+	l      *logrus.Entry
+	access sync.Mutex
+	ops    map[string]*operation
+}
+
+func newOperationTracker(l *logrus.Entry) *operationTracker {
+	return &operationTracker{
+		l:   l.WithFields(logrus.Fields{"func": "operationTracker"}),
+		ops: make(map[string]*operation),
+	}
+}
+
+// run starts fn in background unless operation with the same key exists,
+// then waits for operation until it is done, ctx is done or operationWait
+// passes. Finished operation is forgotten once it is returned.
+func (t *operationTracker) run(ctx context.Context, key string, fn func(op *operation) error) *operation {
+	t.access.Lock()
+	t.expire()
+	op, ok := t.ops[key]
+	if !ok {
+		op = &operation{
+			key:  key,
+			done: make(chan struct{}),
+		}
+		t.ops[key] = op
+		go t.exec(op, fn)
+	} else {
+		t.l.Tracef("Reattach to operation %s", key)
+	}
+	t.access.Unlock()
+
+	timer := time.NewTimer(operationWait)
+	defer timer.Stop()
+
+	select {
+	case <-op.done:
+		t.access.Lock()
+		if t.ops[key] == op {
+			delete(t.ops, key)
+		}
+		t.access.Unlock()
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return op
+}
+
+func (t *operationTracker) exec(op *operation, fn func(op *operation) error) {
+	err := fn(op)
+
+	op.access.Lock()
+	op.err = err
+	if err == nil {
+		op.progress = 100
+	}
+	op.finished = time.Now()
+	op.access.Unlock()
+
+	if err != nil {
+		t.l.Warnf("Operation %s failed: %s", op.key, err.Error())
+	} else {
+		t.l.Tracef("Operation %s done", op.key)
+	}
+	close(op.done)
+}
+
+// expire forgets results nobody came for, caller holds access
+func (t *operationTracker) expire() {
+	for key, op := range t.ops {
+		if !op.Done() {
+			continue
+		}
+		op.access.Lock()
+		expired := time.Since(op.finished) > operationTTL
+		op.access.Unlock()
+		if expired {
+			delete(t.ops, key)
+		}
+	}
+}
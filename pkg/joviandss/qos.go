@@ -0,0 +1,200 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Storage class parameters readIOPS, writeIOPS, readBandwidth and
+// writeBandwidth limit IO of volume with zvol QoS of JovianDSS.
+// Bandwidth is in bytes per second and might have K, M, G or
+// Ki, Mi, Gi suffix. Zero or missing parameter means no limit.
+// Limits are changed later with extension method ModifyVolume.
+
+const (
+	paramReadIOPS       = "readIOPS"
+	paramWriteIOPS      = "writeIOPS"
+	paramReadBandwidth  = "readBandwidth"
+	paramWriteBandwidth = "writeBandwidth"
+)
+
+var bandwidthUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+}
+
+// isQoSParameter checks if parameter sets IO limit
+func isQoSParameter(key string) bool {
+	switch key {
+	case paramReadIOPS, paramWriteIOPS, paramReadBandwidth, paramWriteBandwidth:
+		return true
+	}
+	return false
+}
+
+// parseLimit parses IO limit, bandwidth might have unit suffix
+func parseLimit(key string, value string) (int64, error) {
+	mult := int64(1)
+	num := value
+	if key == paramReadBandwidth || key == paramWriteBandwidth {
+		for _, u := range bandwidthUnits {
+			if strings.HasSuffix(value, u.suffix) {
+				num = strings.TrimSuffix(value, u.suffix)
+				mult = u.mult
+				break
+			}
+		}
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/mult {
+		return 0, fmt.Errorf("Value %s of %s is incorrect, it should be non negative number", value, key)
+	}
+	return n * mult, nil
+}
+
+// parseVolumeQoS extracts IO limits from parameters,
+// set is false if parameters have no limits at all
+func parseVolumeQoS(params map[string]string) (qos rest.VolumeQoS, set bool, err error) {
+	limits := map[string]*int64{
+		paramReadIOPS:       &qos.ReadIOPS,
+		paramWriteIOPS:      &qos.WriteIOPS,
+		paramReadBandwidth:  &qos.ReadBandwidth,
+		paramWriteBandwidth: &qos.WriteBandwidth,
+	}
+	for key, limit := range limits {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		if *limit, err = parseLimit(key, value); err != nil {
+			return qos, false, err
+		}
+		set = true
+	}
+	return qos, set, nil
+}
+
+// applyVolumeQoS sets IO limits of volume given by parameters
+func (cp *ControllerPlugin) applyVolumeQoS(vID string, params map[string]string) error {
+	qos, set, err := parseVolumeQoS(params)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !set {
+		return nil
+	}
+
+	if rErr := (*cp.endpoints[0]).SetVolumeQoS(vID, qos); rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return status.Error(codes.NotFound, rErr.Error())
+		default:
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+	return nil
+}
+
+// ModifyVolume changes IO limits of volume
+//
+// Limits missing in request are kept, new ones are stored
+// in volume record along with other parameters. Volumes created
+// before records were kept get record with limits only.
+func (cp *ControllerPlugin) ModifyVolume(ctx context.Context, req *ModifyVolumeRequest) (*ModifyVolumeResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "ModifyVolume",
+	})
+
+	vID := req.VolumeID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if isNFSVolume(vID) || strings.HasPrefix(vID, "c_") || !cp.ownsVolume(vID) {
+		msg := fmt.Sprintf("Volume %s can not be modified", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	for key := range req.Parameters {
+		if !isQoSParameter(key) {
+			msg := fmt.Sprintf("Parameter %s can not be modified", key)
+			l.Warn(msg)
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+	}
+	if _, _, err := parseVolumeQoS(req.Parameters); err != nil {
+		l.Warn(err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := cp.lockVolume(vID); err != nil {
+		return nil, err
+	}
+	defer cp.unlockVolume(vID)
+
+	rec, err := cp.meta.GetVolume(vID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		if _, err = cp.getVolume(vID); err != nil {
+			return nil, err
+		}
+		l.Debugf("Volume %s has no record, creating one", vID)
+		rec = &VolumeRecord{
+			ID:        vID,
+			Ownership: volumeOwnership(nil, cp.instance),
+		}
+	}
+	// Records stored without plugin metadata have no instance
+	if len(rec.Instance) == 0 {
+		rec.Instance = cp.instance
+	}
+	if rec.Created.IsZero() {
+		rec.Created = time.Now().UTC()
+	}
+
+	params := make(map[string]string)
+	for key, value := range rec.Parameters {
+		if isQoSParameter(key) {
+			params[key] = value
+		}
+	}
+	for key, value := range req.Parameters {
+		params[key] = value
+	}
+
+	if err = cp.applyVolumeQoS(vID, params); err != nil {
+		l.Warn(err.Error())
+		return nil, err
+	}
+
+	if rec.Parameters == nil {
+		rec.Parameters = make(map[string]string)
+	}
+	for key, value := range params {
+		rec.Parameters[key] = value
+	}
+	if err = cp.meta.PutVolume(*rec); err != nil {
+		return nil, err
+	}
+	cp.cacheVolumeParameters(vID, rec.Parameters)
+
+	l.Tracef("Limits of volume %s modified", vID)
+	return &ModifyVolumeResponse{}, nil
+}
@@ -0,0 +1,241 @@
+package joviandss
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	defaultKubeletDir = "/var/lib/kubelet"
+	defaultIqn        = "iqn.csi.2019-04"
+	sysBlockPath      = "/sys/class/block"
+)
+
+// ReconcileReport describes actions taken during node reconciliation
+type ReconcileReport struct {
+	Records    int      // staged target records found
+	Relogged   []string // targets whose sessions were restored
+	LoggedOut  []string // orphan sessions that were closed
+	Failed     []string // targets that could not be processed
+	NoSecret   []string // targets that can not be restored without CHAP secret
+	Consistent []string // targets that had active session
+}
+
+// findStagedRecords walks kubelet plugin directory looking for staged target records
+func findStagedRecords(kubeletDir string) ([]string, error) {
+	root := filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi")
+	records := make([]string, 0)
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return records, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Staging paths might be removed while walking
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, ".starget") {
+			records = append(records, strings.TrimSuffix(path, ".starget"))
+		} else if info.Name() == "starget" {
+			records = append(records, filepath.Dir(path))
+		}
+		return nil
+	})
+	return records, err
+}
+
+// stagedVolumeID returns volume id kubelet stored next to staging path,
+// empty if it is not known
+func stagedVolumeID(stp string) string {
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(stp), "vol_data.json"))
+	if err != nil {
+		return ""
+	}
+	var volData struct {
+		VolumeHandle string `json:"volumeHandle"`
+	}
+	if err = json.Unmarshal(data, &volData); err != nil {
+		return ""
+	}
+	return volData.VolumeHandle
+}
+
+// blockDeviceInUse checks if device or its partitions are mounted or held
+// by other devices, such as device mapper ones
+func blockDeviceInUse(devicePath string, mounter mount.Interface) (bool, error) {
+	dev, err := filepath.EvalSymlinks(devicePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	name := filepath.Base(dev)
+
+	holders, err := filepath.Glob(filepath.Join(sysBlockPath, name, "holders", "*"))
+	if err != nil {
+		return false, err
+	}
+	partHolders, err := filepath.Glob(filepath.Join(sysBlockPath, name, name+"*", "holders", "*"))
+	if err != nil {
+		return false, err
+	}
+	if len(holders)+len(partHolders) > 0 {
+		return true, nil
+	}
+
+	mps, err := mounter.List()
+	if err != nil {
+		return false, err
+	}
+	for _, mp := range mps {
+		// Partitions are named after device with number, optionally after p
+		if strings.HasPrefix(mp.Device, dev) &&
+			strings.TrimLeft(mp.Device[len(dev):], "p0123456789") == "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Reconcile restores sessions of staged volumes and closes orphan iSCSI ones
+//
+// Should be called on node plugin start, as sessions are lost on host reboot
+// and records are lost if staging path is cleared while plugin was down
+func (np *NodePlugin) Reconcile() (*ReconcileReport, error) {
+	l := np.l.WithField("func", "Reconcile")
+	report := &ReconcileReport{}
+
+	kubeletDir := np.cfg.KubeletDir
+	if len(kubeletDir) == 0 {
+		kubeletDir = defaultKubeletDir
+	}
+	iqn := np.cfg.Iqn
+	if len(iqn) == 0 {
+		iqn = defaultIqn
+	}
+
+	stps, err := findStagedRecords(kubeletDir)
+	if err != nil {
+		l.Warnf("Unable to list staged volumes: %s", err.Error())
+		return nil, err
+	}
+	report.Records = len(stps)
+
+	sessions, err := getISCSISessions(mount.NewOSExec())
+	if err != nil {
+		l.Warn(err.Error())
+		return nil, err
+	}
+
+	// Targets of staged volumes, including ones with unreadable records
+	known := make(map[string]bool)
+	for _, stp := range stps {
+		t, err := GetTargetFromPath(np.cfg, np.l, stp)
+		if err != nil {
+			report.Failed = append(report.Failed, stp)
+			if vname := stagedVolumeID(stp); len(vname) > 0 {
+				known[iqn+":"+vname] = true
+			}
+			continue
+		}
+		tname := t.Iqn + ":" + t.Tname
+		if t.Transport == TransportNVMeTCP {
+			tname = t.Nqn
+		}
+		known[tname] = true
+
+		active, err := t.IsAttached()
+		if err != nil {
+			l.Warnf("Unable to check state of %s: %s", tname, err.Error())
+			report.Failed = append(report.Failed, tname)
+			continue
+		}
+		if active {
+			report.Consistent = append(report.Consistent, tname)
+			continue
+		}
+
+		if t.Transport != TransportNVMeTCP {
+			// CSI secrets are not available outside of requests
+			if len(np.cfg.ChapSecret) == 0 {
+				l.Warnf("Unable to restore session of %s, CHAP secret is not configured", tname)
+				report.NoSecret = append(report.NoSecret, tname)
+				continue
+			}
+			chap := getChapCredentials(np.cfg.ChapSecret, t.Tname, np.cfg.Id)
+			t.CoUser, t.CoPass = chap.User, chap.Pass
+			t.CiUser, t.CiPass = chap.TUser, chap.TPass
+		}
+
+		if err = t.StageVolume(); err != nil {
+			l.Warnf("Unable to restore session of %s: %s", tname, err.Error())
+			report.Failed = append(report.Failed, tname)
+			continue
+		}
+		if err = t.SerializeTarget(); err != nil {
+			l.Warnf("Unable to update record of %s: %s", tname, err.Error())
+		}
+		if len(t.FsType) > 0 {
+			if err = t.MigrateSerialization(); err == nil {
+				err = t.FormatMountStaged()
+			}
+			if err != nil {
+				l.Warnf("Unable to mount restored target %s: %s", tname, err.Error())
+				report.Failed = append(report.Failed, tname)
+				continue
+			}
+		}
+		report.Relogged = append(report.Relogged, tname)
+	}
+
+	mounter := mount.New("")
+	for _, s := range sessions {
+		if known[s.Tname] || !strings.HasPrefix(s.Tname, iqn+":") {
+			continue
+		}
+
+		// Session without record might still serve a mounted volume
+		devices, _ := filepath.Glob(strings.Join([]string{deviceIPPath,
+			s.Portal + ":" + s.PortalPort, "iscsi", s.Tname, "lun", "*"}, "-"))
+		inUse := false
+		for _, d := range devices {
+			if inUse, err = blockDeviceInUse(d, mounter); err != nil || inUse {
+				break
+			}
+		}
+		if err != nil || inUse {
+			l.Warnf("Session of %s has no record but its device is in use, it is kept", s.Tname)
+			report.Failed = append(report.Failed, s.Tname)
+			continue
+		}
+
+		t := &Target{
+			Portal:     s.Portal,
+			PortalPort: s.PortalPort,
+			Iqn:        iqn,
+			Tname:      strings.TrimPrefix(s.Tname, iqn+":"),
+			l:          l,
+			cfg:        np.cfg,
+		}
+		if err = t.UnStageVolume(); err != nil {
+			l.Warnf("Unable to logout from orphan target %s: %s", s.Tname, err.Error())
+			report.Failed = append(report.Failed, s.Tname)
+			continue
+		}
+		report.LoggedOut = append(report.LoggedOut, s.Tname)
+	}
+
+	l.Infof("Reconciliation done, records: %d, consistent: %v, relogged: %v, logged out: %v, no secret: %v, failed: %v",
+		report.Records, report.Consistent, report.Relogged, report.LoggedOut, report.NoSecret, report.Failed)
+	return report, nil
+}
@@ -0,0 +1,32 @@
+package joviandss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStagedVolumeID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stp := filepath.Join(dir, "pv", "pvc-1", "globalmount")
+	if err = os.MkdirAll(stp, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if id := stagedVolumeID(stp); id != "" {
+		t.Fatalf("unexpected volume id %s", id)
+	}
+
+	data := `{"driverName":"com.open-e.joviandss.csi","volumeHandle":"static-data"}`
+	if err = ioutil.WriteFile(filepath.Join(dir, "pv", "pvc-1", "vol_data.json"), []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if id := stagedVolumeID(stp); id != "static-data" {
+		t.Fatalf("unexpected volume id %s", id)
+	}
+}
@@ -0,0 +1,403 @@
+package joviandss
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Volumes with replicationInterval parameter are replicated to secondary
+// storage set in controller config. Every interval controller makes
+// c_<volume>_r-<UTC time> snapshot and sends it to the volume with
+// the same name on secondary storage, incrementally from the latest
+// snapshot replica has received. Received snapshot is marked with
+// replicated property, older replication snapshots are deleted.
+
+const (
+	paramReplicationInterval = "replicationInterval"
+
+	replicationSnapshotPrefix = "r-"
+	replicationCheckInterval  = time.Minute
+	minReplicationInterval    = time.Minute
+
+	// ReplicationDisabled volume is not replicated
+	ReplicationDisabled = "disabled"
+	// ReplicationPending no snapshot was received by replica yet
+	ReplicationPending = "pending"
+	// ReplicationRunning snapshot is being sent
+	ReplicationRunning = "running"
+	// ReplicationSynced replica has the latest replication snapshot
+	ReplicationSynced = "synced"
+	// ReplicationFailed sending of the latest snapshot failed
+	ReplicationFailed = "failed"
+)
+
+// replicationSnapshot snapshot made for replication
+type replicationSnapshot struct {
+	Name       string
+	Created    time.Time
+	Replicated bool
+}
+
+// parseReplicationInterval parses interval, empty one disables replication
+func parseReplicationInterval(interval string) (time.Duration, error) {
+	if len(interval) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < minReplicationInterval {
+		return 0, fmt.Errorf("Replication interval %s is incorrect, it should be at least %s",
+			interval, minReplicationInterval)
+	}
+	return d, nil
+}
+
+func replicationSnapshotName(vID string, t time.Time) string {
+	return fmt.Sprintf("c_%s_%s%s", vID, replicationSnapshotPrefix, t.UTC().Format(policyTimeLayout))
+}
+
+// replicationTaskName returns name of task sending snapshot
+func replicationTaskName(sname string) string {
+	snameT := strings.Split(strings.TrimPrefix(sname, "c_"), "_")
+	return snameT[len(snameT)-1]
+}
+
+// isReplicationSnapshot checks if snapshot was made for replication
+func isReplicationSnapshot(sname string) bool {
+	if !strings.HasPrefix(sname, "c_") {
+		return false
+	}
+	snameT := strings.Split(strings.TrimPrefix(sname, "c_"), "_")
+	return len(snameT) == 2 && strings.HasPrefix(snameT[1], replicationSnapshotPrefix)
+}
+
+// volumeReplication returns replication interval of volume, 0 if disabled
+func (cp *ControllerPlugin) volumeReplication(vID string) (time.Duration, error) {
+	params, err := cp.volumeParameters(vID)
+	if err != nil {
+		return 0, err
+	}
+	return parseReplicationInterval(params[paramReplicationInterval])
+}
+
+// listReplicationSnapshots lists replication snapshots of volume, oldest first
+func (cp *ControllerPlugin) listReplicationSnapshots(vID string) ([]replicationSnapshot, error) {
+	snaps, rErr := (*cp.endpoints[0]).ListVolumeSnapshots(vID, isReplicationSnapshot)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	out := []replicationSnapshot{}
+	for _, s := range snaps {
+		ts := strings.TrimPrefix(replicationTaskName(s.Name), replicationSnapshotPrefix)
+		t, err := time.Parse(policyTimeLayout, ts)
+		if err != nil {
+			continue
+		}
+		props, rErr := (*cp.endpoints[0]).GetSnapshotProperties(vID, s.Name)
+		if rErr != nil {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		out = append(out, replicationSnapshot{
+			Name:       s.Name,
+			Created:    t,
+			Replicated: len(props[propReplicated]) > 0,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, nil
+}
+
+// runReplication replicates volumes periodically
+func (cp *ControllerPlugin) runReplication() {
+	ticker := time.NewTicker(replicationCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		cp.replicateVolumes(now)
+	}
+}
+
+// replicateVolumes moves replication of all volumes to the next stage
+func (cp *ControllerPlugin) replicateVolumes(now time.Time) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "replicateVolumes",
+	})
+
+	vnames, rErr := (*cp.endpoints[0]).ListVolumes()
+	if rErr != nil {
+		l.Warnf("Unable to list volumes: %s", rErr.Error())
+		return
+	}
+
+	for _, vID := range vnames {
+		if !cp.ownsVolume(vID) || strings.HasPrefix(vID, "c_") || isNFSVolume(vID) {
+			continue
+		}
+		interval, err := cp.volumeReplication(vID)
+		if err == nil && interval > 0 {
+			err = cp.replicateVolume(l, vID, interval, now)
+		}
+		if err != nil {
+			l.Warnf("Unable to replicate volume %s: %s", vID, err.Error())
+		}
+	}
+}
+
+// replicateVolume checks task sending the latest replication snapshot
+// and makes a new snapshot once interval passes
+func (cp *ControllerPlugin) replicateVolume(l *logrus.Entry, vID string, interval time.Duration, now time.Time) error {
+	// Volume is busy, try on the next check
+	if err := cp.lockVolume(vID); err != nil {
+		return nil
+	}
+	defer cp.unlockVolume(vID)
+
+	snaps, err := cp.listReplicationSnapshots(vID)
+	if err != nil {
+		return err
+	}
+
+	var base, last *replicationSnapshot
+	for i := range snaps {
+		if snaps[i].Replicated {
+			base = &snaps[i]
+		}
+	}
+	if len(snaps) > 0 {
+		last = &snaps[len(snaps)-1]
+	}
+
+	if last != nil && !last.Replicated {
+		return cp.checkReplicationTask(l, vID, last, base, now)
+	}
+
+	if base != nil && now.Sub(base.Created) > 2*interval {
+		l.Warnf("Replica of volume %s is %s behind", vID, now.Sub(base.Created).Truncate(time.Second))
+	}
+
+	if last != nil && now.Sub(last.Created) < interval-replicationCheckInterval/2 {
+		return nil
+	}
+
+	sname := replicationSnapshotName(vID, now)
+	rErr := (*cp.endpoints[0]).CreateSnapshot(vID, sname)
+	if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+	return cp.startReplicationTask(l, vID, sname, base)
+}
+
+// startReplicationTask sends snapshot to replica, incrementally from base
+func (cp *ControllerPlugin) startReplicationTask(l *logrus.Entry, vID string, sname string, base *replicationSnapshot) error {
+	r := cp.cfg.Replica
+	desc := rest.ReplicationTaskDescriptor{
+		Name:     replicationTaskName(sname),
+		Snapshot: sname,
+		// Without base replica is overwritten with full stream
+		Force: base == nil,
+		Destination: rest.ReplicationDestination{
+			Addr:   r.Addr,
+			Port:   r.Port,
+			User:   r.User,
+			Pass:   r.Pass,
+			Pool:   r.Pool,
+			Volume: vID,
+		},
+	}
+	if base != nil {
+		desc.BaseSnapshot = base.Name
+	}
+
+	rErr := (*cp.endpoints[0]).CreateReplicationTask(vID, desc)
+	if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+	l.Tracef("Replication of snapshot %s started", sname)
+	return nil
+}
+
+// checkReplicationTask completes replication of snapshot once task is done
+func (cp *ControllerPlugin) checkReplicationTask(l *logrus.Entry,
+	vID string, last *replicationSnapshot, base *replicationSnapshot, now time.Time) error {
+
+	tname := replicationTaskName(last.Name)
+	task, rErr := (*cp.endpoints[0]).GetReplicationTask(vID, tname)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			// Snapshot was made, but task was not started
+			return cp.startReplicationTask(l, vID, last.Name, base)
+		}
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	switch task.State {
+	case rest.ReplicationTaskDone:
+		props := map[string]string{propReplicated: now.UTC().Format(time.RFC3339)}
+		rErr = (*cp.endpoints[0]).SetSnapshotProperties(vID, last.Name, props)
+		if rErr != nil {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+		(*cp.endpoints[0]).DeleteReplicationTask(vID, tname)
+		l.Tracef("Snapshot %s replicated", last.Name)
+
+		// Only the latest replicated snapshot is needed as a base
+		snaps, err := cp.listReplicationSnapshots(vID)
+		if err != nil {
+			return err
+		}
+		for _, s := range snaps {
+			if s.Name == last.Name {
+				break
+			}
+			rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, s.Name)
+			if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+				return status.Errorf(codes.Internal, rErr.Error())
+			}
+		}
+	case rest.ReplicationTaskFailed:
+		// Clean up, so next check starts over
+		(*cp.endpoints[0]).DeleteReplicationTask(vID, tname)
+		(*cp.endpoints[0]).DeleteSnapshot(vID, last.Name)
+		return fmt.Errorf("Replication of snapshot %s failed: %s", last.Name, task.Message)
+	}
+	return nil
+}
+
+// deleteReplicationSnapshots stops replication of volume and deletes its snapshots
+func (cp *ControllerPlugin) deleteReplicationSnapshots(vID string) error {
+	snaps, rErr := (*cp.endpoints[0]).ListVolumeSnapshots(vID, isReplicationSnapshot)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil
+		}
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	for _, s := range snaps {
+		rErr = (*cp.endpoints[0]).DeleteReplicationTask(vID, replicationTaskName(s.Name))
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+		rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, s.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+	return nil
+}
+
+// replicationStatus reports replication state and lag of volume
+func (cp *ControllerPlugin) replicationStatus(vID string, now time.Time) (*ReplicationStatus, error) {
+	rec, err := cp.meta.GetVolume(vID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %s not found", vID)
+	}
+
+	out := &ReplicationStatus{
+		VolumeID: vID,
+		Interval: rec.Parameters[paramReplicationInterval],
+		State:    ReplicationDisabled,
+	}
+	if len(out.Interval) == 0 || cp.replica == nil {
+		return out, nil
+	}
+
+	snaps, err := cp.listReplicationSnapshots(vID)
+	if err != nil {
+		return nil, err
+	}
+
+	out.State = ReplicationPending
+	for _, s := range snaps {
+		if s.Replicated {
+			out.State = ReplicationSynced
+			out.LastSync = s.Created.Unix()
+			out.Lag = int64(now.Sub(s.Created).Seconds())
+		}
+	}
+
+	if len(snaps) > 0 && !snaps[len(snaps)-1].Replicated {
+		last := snaps[len(snaps)-1]
+		task, rErr := (*cp.endpoints[0]).GetReplicationTask(vID, replicationTaskName(last.Name))
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+		if task != nil {
+			switch task.State {
+			case rest.ReplicationTaskRunning:
+				out.State = ReplicationRunning
+				out.Progress = task.Progress
+			case rest.ReplicationTaskFailed:
+				out.State = ReplicationFailed
+				out.Message = task.Message
+			}
+		}
+	}
+	return out, nil
+}
+
+// GetReplicationStatus provides replication state of volume
+func (cp *ControllerPlugin) GetReplicationStatus(ctx context.Context, req *ReplicationStatusRequest) (*ReplicationStatus, error) {
+	vID := req.VolumeID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if isNFSVolume(vID) || strings.HasPrefix(vID, "c_") || !cp.ownsVolume(vID) {
+		msg := fmt.Sprintf("Volume %s is not replicated", vID)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+	return cp.replicationStatus(vID, time.Now())
+}
+
+// PromoteReplica makes replica of volume on secondary storage writable
+//
+// Only secondary storage is used, so replica can be promoted
+// when primary storage is not available
+func (cp *ControllerPlugin) PromoteReplica(ctx context.Context, req *PromoteReplicaRequest) (*PromoteReplicaResponse, error) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "PromoteReplica",
+	})
+
+	vID := req.VolumeID
+	if len(vID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume id missing in request")
+	}
+	if cp.replica == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Secondary storage is not configured")
+	}
+	if isNFSVolume(vID) || strings.HasPrefix(vID, "c_") || !cp.ownsVolume(vID) {
+		msg := fmt.Sprintf("Volume %s is not replicated", vID)
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	rErr := (*cp.replica).PromoteReplica(vID)
+	if rErr != nil {
+		switch rErr.GetCode() {
+		case rest.RestResourceDNE:
+			return nil, status.Errorf(codes.NotFound, "Replica of volume %s not found", vID)
+		case rest.RestResourceBusy:
+			return nil, status.Error(codes.FailedPrecondition, rErr.Error())
+		default:
+			return nil, status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+
+	l.Tracef("Replica of volume %s promoted", vID)
+	return &PromoteReplicaResponse{}, nil
+}
@@ -0,0 +1,52 @@
+package joviandss
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const strippedValue = "<Cleared>"
+
+// secretContextKeys context keys that may carry credentials
+var secretContextKeys = map[string]bool{
+	"name":        true,
+	"pass":        true,
+	"mname":       true,
+	"mpass":       true,
+	ChapSecretKey: true,
+}
+
+// stripSecrets returns printable representation of CSI request with
+// all secret bearing fields cleared
+func stripSecrets(req interface{}) string {
+	msg, ok := req.(proto.Message)
+	if !ok || reflect.ValueOf(msg).IsNil() {
+		return fmt.Sprintf("%+v", req)
+	}
+
+	c := proto.Clone(msg)
+	v := reflect.ValueOf(c).Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprintf("%+v", c)
+	}
+
+	for _, fName := range []string{"Secrets", "PublishContext", "VolumeContext"} {
+		f := v.FieldByName(fName)
+		if !f.IsValid() || f.Kind() != reflect.Map || f.IsNil() {
+			continue
+		}
+		m, ok := f.Interface().(map[string]string)
+		if !ok {
+			continue
+		}
+		for k := range m {
+			if fName == "Secrets" || secretContextKeys[k] {
+				m[k] = strippedValue
+			}
+		}
+	}
+
+	return fmt.Sprintf("%+v", c)
+}
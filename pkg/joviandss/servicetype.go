@@ -70,6 +70,9 @@ func GetPluginServer(cfg *Config, l *logrus.Entry) (s *PluginServer, err error)
 			}
 			csi.RegisterControllerServer(s.server, s.cp)
 			s.l.Info("Register Controller Plugin")
+
+			RegisterExtensionServer(s.server, s.cp)
+			s.l.Info("Register Extension service")
 		}
 
 		if v == NodePluginName {
@@ -102,6 +105,7 @@ func (s *PluginServer) grpcErrorHandler(
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
+	s.l.WithFields(logrus.Fields{"grpc": info.FullMethod}).Tracef("req: %s", stripSecrets(req))
 	resp, err := handler(ctx, req)
 	if err != nil {
 		s.l.WithFields(logrus.Fields{"grpc": "Fail"}).Warn(err)
@@ -0,0 +1,126 @@
+package joviandss
+
+import (
+	"fmt"
+	osexec "os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	// iscsiadm exit code for absence of sessions or node records
+	iscsiErrNoObjsFound = 21
+)
+
+// iscsiSession describes active iSCSI session
+type iscsiSession struct {
+	ID         string
+	Portal     string
+	PortalPort string
+	Tname      string // full target name, iqn:volume
+}
+
+// isNoObjsFound checks if iscsiadm failed because there is nothing to process
+func isNoObjsFound(err error, out []byte) bool {
+	if ee, ok := err.(*osexec.ExitError); ok && ee.ExitCode() == iscsiErrNoObjsFound {
+		return true
+	}
+	o := string(out)
+	return strings.Contains(o, "No active sessions") ||
+		strings.Contains(o, "No matching sessions") ||
+		strings.Contains(o, "No records found")
+}
+
+// parseISCSISessions parses output of iscsiadm -m session
+//
+// tcp: [1] 192.168.0.3:3260,1 iqn.2020-04.com.open-e.cinder:volume (non-flash)
+func parseISCSISessions(out string) []iscsiSession {
+	sessions := make([]iscsiSession, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		id := strings.Trim(fields[1], "[]")
+		portal := strings.Split(fields[2], ",")[0]
+		i := strings.LastIndex(portal, ":")
+		if i < 0 {
+			continue
+		}
+		sessions = append(sessions, iscsiSession{
+			ID:         id,
+			Portal:     strings.Trim(portal[:i], "[]"),
+			PortalPort: portal[i+1:],
+			Tname:      fields[3],
+		})
+	}
+	return sessions
+}
+
+// getISCSISessions lists active iSCSI sessions of the host
+func getISCSISessions(exec mount.Exec) ([]iscsiSession, error) {
+	out, err := exec.Run("iscsiadm", "-m", "session")
+	if err != nil {
+		if isNoObjsFound(err, out) {
+			return []iscsiSession{}, nil
+		}
+		return nil, fmt.Errorf("Unable to list iscsi sessions: %s, Out: %s", err.Error(), string(out))
+	}
+	return parseISCSISessions(string(out)), nil
+}
+
+// GetSession looks for active session of the target
+func (t *Target) GetSession() (*iscsiSession, error) {
+	sessions, err := getISCSISessions(t.executor())
+	if err != nil {
+		return nil, err
+	}
+	tname := t.Iqn + ":" + t.Tname
+	for i := range sessions {
+		if sessions[i].Tname == tname && sessions[i].Portal == t.Portal {
+			return &sessions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetTargetFromSession reconstructs Target from active iSCSI session of the volume
+//
+// Used when record of the staged target is lost, returns nil if there is no session
+func GetTargetFromSession(cfg *NodeCfg, log *logrus.Entry, stp string, vname string) (*Target, error) {
+	l := log.WithFields(logrus.Fields{
+		"node": cfg.Id,
+		"obj":  "Target",
+	})
+
+	sessions, err := getISCSISessions(mount.NewOSExec())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sessions {
+		if !strings.HasSuffix(s.Tname, ":"+vname) {
+			continue
+		}
+		iqn := strings.TrimSuffix(s.Tname, ":"+vname)
+		fullPortal := s.Portal + ":" + s.PortalPort
+		t := &Target{
+			STPath:     stp,
+			DPath:      strings.Join([]string{deviceIPPath, fullPortal, "iscsi", s.Tname, "lun", "0"}, "-"),
+			Portal:     s.Portal,
+			PortalPort: s.PortalPort,
+			Iqn:        iqn,
+			Tname:      vname,
+			Lun:        "0",
+			TProtocol:  "tcp",
+			MountFlags: make([]string, 0),
+		}
+		t.l = l
+		t.cfg = cfg
+		l.Debugf("Target %s recovered from session %s", s.Tname, s.ID)
+		return t, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,269 @@
+package joviandss
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-e/JovianDSS-KubernetesCSI/pkg/rest"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Snapshot policy makes periodic snapshots of volume and prunes expired ones.
+// Policy is a list of rules <interval>:<number of snapshots to keep>,
+// for example "hourly:24,daily:7". Interval is hourly, daily, weekly
+// or a duration like 30m. Policy is set with snapshotPolicy parameter
+// of storage class, volumes without it use policy from controller config.
+//
+// Policy snapshots are named <volume>_p-<interval>-<UTC time>
+// and are hidden from ListSnapshots unless config says otherwise.
+
+const (
+	paramSnapshotPolicy = "snapshotPolicy"
+	snapshotPolicyNone  = "none"
+
+	policySnapshotPrefix = "p-"
+	policyTimeLayout     = "20060102150405"
+	policyCheckInterval  = time.Minute
+	minPolicyInterval    = time.Minute
+)
+
+var policyIntervals = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// policyRule keeps Keep latest snapshots made every Interval
+type policyRule struct {
+	Label    string
+	Interval time.Duration
+	Keep     int
+}
+
+// policySnapshot snapshot made by policy rule
+type policySnapshot struct {
+	Name    string
+	Created time.Time
+	Clones  string
+}
+
+// parseSnapshotPolicy parses policy, empty policy or none has no rules
+func parseSnapshotPolicy(policy string) ([]policyRule, error) {
+	policy = strings.TrimSpace(policy)
+	if len(policy) == 0 || policy == snapshotPolicyNone {
+		return nil, nil
+	}
+
+	out := []policyRule{}
+	seen := map[string]bool{}
+	for _, r := range strings.Split(policy, ",") {
+		rT := strings.Split(strings.TrimSpace(r), ":")
+		if len(rT) != 2 {
+			return nil, fmt.Errorf("Snapshot policy rule %s should be <interval>:<keep>", r)
+		}
+
+		label := rT[0]
+		interval, ok := policyIntervals[label]
+		if !ok {
+			d, err := time.ParseDuration(label)
+			if err != nil || d < minPolicyInterval || strings.ContainsAny(label, "_-") {
+				return nil, fmt.Errorf("Snapshot policy interval %s is incorrect", label)
+			}
+			interval = d
+		}
+
+		keep, err := strconv.Atoi(rT[1])
+		if err != nil || keep < 1 {
+			return nil, fmt.Errorf("Snapshot policy rule %s should keep at least one snapshot", r)
+		}
+
+		if seen[label] {
+			return nil, fmt.Errorf("Snapshot policy interval %s is set twice", label)
+		}
+		seen[label] = true
+		out = append(out, policyRule{Label: label, Interval: interval, Keep: keep})
+	}
+	return out, nil
+}
+
+func policySnapshotName(vID string, label string, t time.Time) string {
+	return fmt.Sprintf("%s_%s%s-%s", vID, policySnapshotPrefix, label, t.UTC().Format(policyTimeLayout))
+}
+
+// isPolicySnapshot checks if snapshot was made by snapshot policy
+func isPolicySnapshot(sname string) bool {
+	snameT := strings.Split(sname, "_")
+	return len(snameT) == 2 && strings.HasPrefix(snameT[1], policySnapshotPrefix)
+}
+
+// parsePolicySnapshot extracts rule label and creation time from snapshot name
+func parsePolicySnapshot(sname string) (string, time.Time, bool) {
+	if !isPolicySnapshot(sname) {
+		return "", time.Time{}, false
+	}
+	sID := strings.TrimPrefix(strings.Split(sname, "_")[1], policySnapshotPrefix)
+	sT := strings.Split(sID, "-")
+	if len(sT) != 2 {
+		return "", time.Time{}, false
+	}
+	t, err := time.Parse(policyTimeLayout, sT[1])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return sT[0], t, true
+}
+
+// volumePolicy returns snapshot policy rules of volume
+func (cp *ControllerPlugin) volumePolicy(vID string) ([]policyRule, error) {
+	policy := cp.cfg.SnapshotPolicy
+
+	params, err := cp.volumeParameters(vID)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := params[paramSnapshotPolicy]; ok {
+		policy = p
+	}
+	return parseSnapshotPolicy(policy)
+}
+
+// runSnapshotPolicies applies snapshot policies periodically
+func (cp *ControllerPlugin) runSnapshotPolicies() {
+	ticker := time.NewTicker(policyCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		cp.applySnapshotPolicies(now)
+	}
+}
+
+// applySnapshotPolicies makes and prunes policy snapshots of all volumes
+func (cp *ControllerPlugin) applySnapshotPolicies(now time.Time) {
+	l := cp.l.WithFields(logrus.Fields{
+		"func": "applySnapshotPolicies",
+	})
+
+	vnames, rErr := (*cp.endpoints[0]).ListVolumes()
+	if rErr != nil {
+		l.Warnf("Unable to list volumes: %s", rErr.Error())
+		return
+	}
+
+	for _, vID := range vnames {
+		if !cp.ownsVolume(vID) || strings.HasPrefix(vID, "c_") || isNFSVolume(vID) {
+			continue
+		}
+		if err := cp.applyVolumePolicy(l, vID, now); err != nil {
+			l.Warnf("Unable to apply snapshot policy of volume %s: %s", vID, err.Error())
+		}
+	}
+}
+
+// applyVolumePolicy makes policy snapshots that are due and deletes expired ones
+func (cp *ControllerPlugin) applyVolumePolicy(l *logrus.Entry, vID string, now time.Time) error {
+	rules, err := cp.volumePolicy(vID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	// Volume is busy, try on the next check
+	if err = cp.lockVolume(vID); err != nil {
+		return nil
+	}
+	defer cp.unlockVolume(vID)
+
+	snaps, rErr := (*cp.endpoints[0]).ListVolumeSnapshots(vID, isPolicySnapshot)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil
+		}
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	byLabel := map[string][]policySnapshot{}
+	for _, s := range snaps {
+		label, t, ok := parsePolicySnapshot(s.Name)
+		if !ok {
+			continue
+		}
+		byLabel[label] = append(byLabel[label], policySnapshot{Name: s.Name, Created: t, Clones: s.Clones})
+	}
+
+	for _, r := range rules {
+		ps := byLabel[r.Label]
+		sort.Slice(ps, func(i, j int) bool { return ps[i].Created.After(ps[j].Created) })
+
+		// Checks are not aligned with intervals, allow half of check
+		// interval earlier, so snapshots do not drift
+		if len(ps) == 0 || now.Sub(ps[0].Created) >= r.Interval-policyCheckInterval/2 {
+			sname := policySnapshotName(vID, r.Label, now)
+			if err = cp.createPolicySnapshot(vID, sname, r.Label, now); err != nil {
+				return err
+			}
+			l.Tracef("Policy snapshot %s created", sname)
+			ps = append([]policySnapshot{{Name: sname, Created: now}}, ps...)
+		}
+
+		if len(ps) <= r.Keep {
+			continue
+		}
+		for _, s := range ps[r.Keep:] {
+			if len(splitClones(s.Clones)) > 0 {
+				l.Warnf("Expired policy snapshot %s has clones and is kept", s.Name)
+				continue
+			}
+			rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, s.Name)
+			if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+				return status.Errorf(codes.Internal, rErr.Error())
+			}
+			l.Tracef("Expired policy snapshot %s deleted", s.Name)
+		}
+	}
+	return nil
+}
+
+// createPolicySnapshot makes snapshot labeled with policy rule
+func (cp *ControllerPlugin) createPolicySnapshot(vID string, sname string, label string, now time.Time) error {
+	rErr := (*cp.endpoints[0]).CreateSnapshot(vID, sname)
+	if rErr != nil && rErr.GetCode() != rest.RestObjectExists {
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	sID := strings.TrimPrefix(sname, vID+"_")
+	return cp.meta.PutSnapshot(sID, SnapshotRecord{
+		ID:           sname,
+		SourceVolume: vID,
+		Policy:       label,
+		Ownership: Ownership{
+			Instance: cp.instance,
+			Created:  now,
+		},
+	})
+}
+
+// deletePolicySnapshots deletes policy snapshots of volume that have no clones
+func (cp *ControllerPlugin) deletePolicySnapshots(vID string) error {
+	snaps, rErr := (*cp.endpoints[0]).ListVolumeSnapshots(vID, isPolicySnapshot)
+	if rErr != nil {
+		if rErr.GetCode() == rest.RestResourceDNE {
+			return nil
+		}
+		return status.Errorf(codes.Internal, rErr.Error())
+	}
+
+	for _, s := range snaps {
+		if len(splitClones(s.Clones)) > 0 {
+			continue
+		}
+		rErr = (*cp.endpoints[0]).DeleteSnapshot(vID, s.Name)
+		if rErr != nil && rErr.GetCode() != rest.RestResourceDNE {
+			return status.Errorf(codes.Internal, rErr.Error())
+		}
+	}
+	return nil
+}
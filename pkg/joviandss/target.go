@@ -2,12 +2,16 @@ package joviandss
 
 import (
 	"github.com/sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/util/mount"
 )
 
 // Target stores info about iscsi target
 type Target struct {
 	l          *logrus.Entry
 	cfg        *NodeCfg
+	exec       mount.Exec
+	sysRoot    string // sysfs mount point, /sys if empty
+	Version    int    // version of the record format
 	STPath     string // Where target is staged
 	TPath      string // Where target should be mounted
 	DPath      string // Device representation in system
@@ -18,8 +22,15 @@ type Target struct {
 	Tname      string // target name = volumeID
 	CoUser     string // Chap outgoing password
 	CoPass     string // Chap outgoing Password
+	CiUser     string // Chap incoming user, used by target in mutual CHAP
+	CiPass     string // Chap incoming password, used by target in mutual CHAP
 	TProtocol  string // tcp, others are not supported
+	Transport  string // iscsi or nvme-tcp, iscsi if empty
+	Nqn        string // NVMe subsystem qualified name
+	Nsid       string // NVMe namespace id
+	HostNqn    string // NVMe qualified name node connects with
 
 	FsType     string   // Type of file system
 	MountFlags []string // mount tool arguments
+	ReadOnly   bool     // volume is published in read only mode
 }
@@ -1,6 +1,9 @@
 package joviandss
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -21,8 +24,37 @@ import (
 
 const (
 	deviceIPPath = "/dev/disk/by-path/ip"
+
+	defaultFsType = "ext4"
+
+	// targetRecordVersion current version of staged Target record
+	targetRecordVersion = 1
+
+	// checksumHeader starts first line of staged Target record followed
+	// by sha256 of the rest of the record, line is a YAML comment
+	checksumHeader = "# checksum: "
 )
 
+// mkfsOptions explicit mkfs arguments for supported file systems
+var mkfsOptions = map[string][]string{
+	"ext3":  {"-F", "-m0", "-E", "nodiscard"},
+	"ext4":  {"-F", "-m0", "-E", "nodiscard"},
+	"xfs":   {"-f", "-K"},
+	"btrfs": {"-f", "-K"},
+}
+
+// stargetPath returns path of Target record for the staging path
+//
+// Staging directory is used as a mount point, so record is kept next to it
+func stargetPath(stp string) string {
+	return filepath.Clean(stp) + ".starget"
+}
+
+// legacyStargetPath returns path of Target record made by older plugin versions
+func legacyStargetPath(stp string) string {
+	return filepath.Join(stp, "starget")
+}
+
 // GetTarget constructs basic Target structure
 func GetTarget(cfg *NodeCfg, log *logrus.Entry, tp string) (t *Target, err error) {
 	l := log.WithFields(logrus.Fields{
@@ -51,11 +83,14 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 	})
 
 	var ctx map[string]string
+	var secrets map[string]string
 	var msg string
 	var vID string
+	stage := false
 
 	var fsType string
 	var mountFlags []string
+	var readOnly bool
 
 	sTPath := ""
 	tPath := ""
@@ -64,7 +99,9 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 	if d, ok := r.(csi.NodeStageVolumeRequest); ok {
 
 		l.Trace("Processing Stage request")
+		stage = true
 		ctx = d.GetPublishContext()
+		secrets = d.GetSecrets()
 		sTPath = d.GetStagingTargetPath()
 		if len(sTPath) == 0 {
 			msg = fmt.Sprintf("Request do not contain StagingTargetPath.")
@@ -78,11 +115,11 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 			l.Warn(msg)
 			return nil, status.Error(codes.InvalidArgument, msg)
 		}
-		mount := d.GetVolumeCapability().GetMount()
-		if mount != nil {
-			fsType = mount.GetFsType()
-			mountFlags = mount.GetMountFlags()
+		if fsType, mountFlags, err = selectFsType(cfg, d.GetVolumeCapability(), d.GetVolumeContext()); err != nil {
+			l.Warn(err.Error())
+			return nil, err
 		}
+		readOnly = isReadOnlyMode(d.GetVolumeCapability().GetAccessMode().GetMode())
 
 	}
 
@@ -111,6 +148,10 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 			l.Warn(msg)
 			return nil, status.Error(codes.InvalidArgument, msg)
 		}
+		if fsType, mountFlags, err = selectFsType(cfg, d.GetVolumeCapability(), d.GetVolumeContext()); err != nil {
+			l.Warn(err.Error())
+			return nil, err
+		}
 	}
 
 	var p string
@@ -127,13 +168,22 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
+	if ctx["readonly"] == "true" {
+		readOnly = true
+	}
+
+	if ctx["transport"] == TransportNVMeTCP {
+		return getNVMeTargetFromCtx(cfg, l, ctx, vID, p, sTPath, tPath,
+			fsType, mountFlags, readOnly)
+	}
+
 	var pp string
 	if len(ctx["port"]) > 0 {
 		pp = ctx["port"]
 	} else {
 		pp = strconv.Itoa(cfg.Port)
 	}
-	if len(pp) == 0 {
+	if pp == "0" || len(pp) == 0 {
 		l.Debug("Use default port: 3260")
 		pp = "3260"
 	}
@@ -145,18 +195,16 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 		return nil, status.Error(codes.InvalidArgument, msg)
 	}
 
-	coUser := ctx["name"]
-	if len(coUser) == 0 {
-		msg = fmt.Sprintf("Request do not contain CHAP name")
-		l.Warn(msg)
-		return nil, status.Error(codes.InvalidArgument, msg)
-	}
-
-	coPass := ctx["pass"]
-	if len(coUser) == 0 {
-		msg = fmt.Sprintf("Request do not contain CHAP pass")
-		l.Warn(msg)
-		return nil, status.Error(codes.InvalidArgument, msg)
+	// CHAP credentials are only required to stage volume
+	var chap chapCredentials
+	if stage {
+		chapSecret := getChapSecret(secrets, cfg.ChapSecret)
+		if len(chapSecret) == 0 {
+			msg = fmt.Sprintf("CHAP secret is not configured")
+			l.Warn(msg)
+			return nil, status.Error(codes.FailedPrecondition, msg)
+		}
+		chap = getChapCredentials(chapSecret, vID, cfg.Id)
 	}
 	lun := ctx["lun"]
 	if len(lun) == 0 {
@@ -170,7 +218,6 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 
 	dPath := strings.Join([]string{deviceIPPath, fullPortal, "iscsi", tname, "lun", lun}, "-")
 
-	// TODO: Provide default file system selection
 	t = &Target{
 		STPath:     sTPath,
 		TPath:      tPath,
@@ -180,15 +227,69 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 		Iqn:        iqn,
 		Tname:      vID,
 		Lun:        lun,
-		CoUser:     coUser, // Chap outgoing password
-		CoPass:     coPass, // Chap outgoing Password
+		CoUser:     chap.User,  // Chap outgoing password
+		CoPass:     chap.Pass,  // Chap outgoing Password
+		CiUser:     chap.TUser, // Chap incoming user
+		CiPass:     chap.TPass, // Chap incoming Password
 		TProtocol:  "tcp",
-		FsType:     "ext3",
+		Transport:  TransportISCSI,
+		FsType:     fsType,
 		MountFlags: make([]string, 0),
+		ReadOnly:   readOnly,
 	}
 
-	if len(fsType) > 0 {
-		t.FsType = fsType
+	if len(mountFlags) > 0 {
+		t.MountFlags = mountFlags
+	}
+
+	t.l = l
+
+	t.cfg = cfg
+	return t, nil
+}
+
+// getNVMeTargetFromCtx constructs Target of volume exported over NVMe/TCP
+//
+// Device path is known only after subsystem is connected
+func getNVMeTargetFromCtx(cfg *NodeCfg, l *logrus.Entry, ctx map[string]string,
+	vID string, p string, sTPath string, tPath string,
+	fsType string, mountFlags []string, readOnly bool) (*Target, error) {
+
+	nqn := ctx["nqn"]
+	if len(nqn) == 0 {
+		msg := fmt.Sprintf("Context do not contain nqn value")
+		l.Warn(msg)
+		return nil, status.Error(codes.InvalidArgument, msg)
+	}
+
+	pp := ctx["port"]
+	if len(pp) == 0 && cfg.NvmePort > 0 {
+		pp = strconv.Itoa(cfg.NvmePort)
+	}
+	if len(pp) == 0 {
+		l.Debugf("Use default port: %d", defaultNVMePort)
+		pp = strconv.Itoa(defaultNVMePort)
+	}
+
+	nsid := ctx["nsid"]
+	if len(nsid) == 0 {
+		nsid = "1"
+	}
+
+	t := &Target{
+		STPath:     sTPath,
+		TPath:      tPath,
+		Portal:     p,
+		PortalPort: pp,
+		Tname:      vID,
+		TProtocol:  "tcp",
+		Transport:  TransportNVMeTCP,
+		Nqn:        nqn,
+		Nsid:       nsid,
+		HostNqn:    ctx["hostnqn"],
+		FsType:     fsType,
+		MountFlags: make([]string, 0),
+		ReadOnly:   readOnly,
 	}
 
 	if len(mountFlags) > 0 {
@@ -196,37 +297,88 @@ func GetTargetFromReq(cfg *NodeCfg, log *logrus.Entry, r interface{}) (t *Target
 	}
 
 	t.l = l
-
 	t.cfg = cfg
 	return t, nil
 }
 
+// selectFsType picks file system and mount flags for volume capability
+//
+// File system from capability has priority over storage class parameter
+// and node config, empty value is returned for block volumes
+func selectFsType(cfg *NodeCfg, c *csi.VolumeCapability, vCtx map[string]string) (string, []string, error) {
+	mount := c.GetMount()
+	if mount == nil {
+		return "", nil, nil
+	}
+
+	fsType := mount.GetFsType()
+	if len(fsType) == 0 {
+		fsType = vCtx["fsType"]
+	}
+	if len(fsType) == 0 {
+		fsType = cfg.FsType
+	}
+	if len(fsType) == 0 {
+		fsType = defaultFsType
+	}
+	if _, ok := mkfsOptions[fsType]; !ok {
+		msg := fmt.Sprintf("File system %s is not supported", fsType)
+		return "", nil, status.Error(codes.InvalidArgument, msg)
+	}
+	return fsType, mount.GetMountFlags(), nil
+}
+
 // GetTargetFromPath recoinstruct Target on the basis of the path
 func GetTargetFromPath(cfg *NodeCfg, log *logrus.Entry, path string) (t *Target, err error) {
 
 	t = &Target{}
-	tp := path + "/starget"
+	t.l = log.WithFields(logrus.Fields{
+		"node": cfg.Id,
+		"obj":  "Target",
+	})
+	tp := stargetPath(path)
+	if exists, _ := mount.PathExists(tp); exists == false {
+		tp = legacyStargetPath(path)
+	}
 	err = t.DeSerializeTarget(tp)
 	if err != nil {
 		msg := fmt.Sprintf("Unable to serialize Target file %s. Error: %s", path, err.Error())
 		log.Error(msg)
 		return nil, status.Error(codes.Internal, msg)
 	}
-	t.l = log.WithFields(logrus.Fields{
-		"node": cfg.Id,
-		"obj":  "Target",
-	})
+	t.STPath = path
 	t.cfg = cfg
 	return t, nil
 }
 
+// MigrateSerialization moves record made by older plugin versions out of staging path
+//
+// Legacy record is stored inside of staging directory and would be hidden
+// once file system is mounted on it
+func (t *Target) MigrateSerialization() error {
+	lp := legacyStargetPath(t.STPath)
+	if exists, _ := mount.PathExists(lp); exists == false {
+		return nil
+	}
+	if err := t.SerializeTarget(); err != nil {
+		return err
+	}
+	return t.deleteRecord(lp)
+}
+
 // SerializeTarget stores Target data to file
+//
+// Record is written to temporary file that replaces old one,
+// so crash never leaves partially written record
 func (t *Target) SerializeTarget() error {
 
 	var msg string
 	d := *t
-	d.CoUser = "<Cleared>"
-	d.CoPass = "<Cleared>"
+	d.CoUser = strippedValue
+	d.CoPass = strippedValue
+	d.CiUser = strippedValue
+	d.CiPass = strippedValue
+	d.Version = targetRecordVersion
 
 	data, err := yaml.Marshal(d)
 	if err != nil {
@@ -234,28 +386,49 @@ func (t *Target) SerializeTarget() error {
 		msg = fmt.Sprintf("Unable to serialize Target %+v.", d)
 		return status.Error(codes.Internal, msg)
 	}
+	data = append([]byte(checksumHeader+recordChecksum(data)+"\n"), data...)
 
-	tp := t.STPath + "/starget"
-	f, err := os.Create(tp)
+	tp := stargetPath(t.STPath)
+	tmp := tp + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 
 	if err != nil {
 
-		msg = fmt.Sprintf("Unable to create Target data file %s err %s", tp, err.Error())
+		msg = fmt.Sprintf("Unable to create Target data file %s err %s", tmp, err.Error())
 		return status.Error(codes.Internal, msg)
 	}
 
-	defer f.Close()
-	_, err = f.Write(data)
-
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
 	if err != nil {
-		msg = fmt.Sprintf("Unable to write Target data to %s err %s", tp, err.Error())
+		os.Remove(tmp)
+		msg = fmt.Sprintf("Unable to write Target data to %s err %s", tmp, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	if err = os.Rename(tmp, tp); err != nil {
+		os.Remove(tmp)
+		msg = fmt.Sprintf("Unable to store Target data to %s err %s", tp, err.Error())
 		return status.Error(codes.Internal, msg)
 	}
-	f.Sync()
+
+	// Rename is durable only after directory is synced
+	if dir, err := os.Open(filepath.Dir(tp)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	t.Version = targetRecordVersion
 	return nil
 }
 
 // DeSerializeTarget restores Target form data file
+//
+// Corrupted record is moved aside, so that it can be inspected later
 func (t *Target) DeSerializeTarget(stp string) error {
 	var msg string
 
@@ -266,20 +439,81 @@ func (t *Target) DeSerializeTarget(stp string) error {
 		return status.Error(codes.Internal, msg)
 	}
 
-	err = yaml.Unmarshal(data, t)
+	if len(data) == 0 {
+		err = errors.New("record is empty")
+	} else if data, err = verifyChecksum(data); err == nil {
+		err = yaml.Unmarshal(data, t)
+	}
 	if err != nil {
-		msg = fmt.Sprintf("Unable to deirialize Target from file  %s", stp)
+		qp := fmt.Sprintf("%s.corrupted-%d", stp, time.Now().Unix())
+		msg = fmt.Sprintf("Unable to deirialize Target from file %s, Err: %s, moving it to %s",
+			stp, err.Error(), qp)
 		t.l.Warn(msg)
-		return status.Error(codes.Internal, msg)
+		os.Rename(stp, qp)
+		return status.Error(codes.DataLoss, msg)
 	}
 
+	return t.migrateRecord()
+}
+
+// migrateRecord upgrades Target restored from older record format
+func (t *Target) migrateRecord() error {
+	switch t.Version {
+	case 0:
+		// Records without version might lack protocol and mount flags
+		if t.MountFlags == nil {
+			t.MountFlags = make([]string, 0)
+		}
+		if len(t.TProtocol) == 0 {
+			t.TProtocol = "tcp"
+		}
+		if len(t.Transport) == 0 {
+			t.Transport = TransportISCSI
+		}
+		t.Version = targetRecordVersion
+	case targetRecordVersion:
+	default:
+		msg := fmt.Sprintf("Target record version %d is not supported", t.Version)
+		return status.Error(codes.FailedPrecondition, msg)
+	}
 	return nil
 }
 
+// recordChecksum calculates checksum of serialized record
+func recordChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChecksum checks record against its checksum line and returns
+// the rest of the record, records without the line are returned as is
+func verifyChecksum(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(checksumHeader)) {
+		return data, nil
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, errors.New("record is truncated")
+	}
+	if string(data[len(checksumHeader):i]) != recordChecksum(data[i+1:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return data[i+1:], nil
+}
+
 // DeleteSerialization deletes record file about target
 func (t *Target) DeleteSerialization() (err error) {
+	for _, stp := range []string{stargetPath(t.STPath), legacyStargetPath(t.STPath)} {
+		if err = t.deleteRecord(stp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRecord deletes particular record file
+func (t *Target) deleteRecord(stp string) (err error) {
 	var msg string
-	stp := t.STPath + "/starget"
 	var exists bool
 	if exists, err = mount.PathExists(stp); err != nil {
 		msg = fmt.Sprintf("Unable to identify serialization data for file %s. Because: %s", stp, err.Error())
@@ -290,7 +524,7 @@ func (t *Target) DeleteSerialization() (err error) {
 	if exists == false {
 		return nil
 	}
-	if err = os.Remove(t.STPath + "/starget"); err == nil {
+	if err = os.Remove(stp); err == nil {
 		return nil
 	}
 
@@ -302,7 +536,7 @@ func (t *Target) DeleteSerialization() (err error) {
 // SetChapCred puts chap credantial to local db
 func (t *Target) SetChapCred() error {
 
-	exec := mount.NewOSExec()
+	exec := t.executor()
 	tname := t.Iqn + ":" + t.Tname
 
 	t.l.Tracef("Target: %s", tname)
@@ -325,13 +559,28 @@ func (t *Target) SetChapCred() error {
 		return fmt.Errorf("iscsi: failed to update node session password error: %v", string(out))
 	}
 
+	if len(t.CiUser) == 0 {
+		return nil
+	}
+
+	out, err = exec.Run("iscsiadm", "-m", "node", "-p", t.Portal, "-T", tname, "-o", "update", "-n",
+		"node.session.auth.username_in", "-v", t.CiUser)
+	if err != nil {
+		return fmt.Errorf("iscsi: failed to update node session incoming user error: %v", string(out))
+	}
+	out, err = exec.Run("iscsiadm", "-m", "node", "-p", t.Portal, "-T", tname, "-o", "update", "-n",
+		"node.session.auth.password_in", "-v", t.CiPass)
+	if err != nil {
+		return fmt.Errorf("iscsi: failed to update node session incoming password error: %v", string(out))
+	}
+
 	return nil
 }
 
 // ClearChapCred sets chap credential to empty values
 func (t *Target) ClearChapCred() error {
 
-	exec := mount.NewOSExec()
+	exec := t.executor()
 
 	tname := t.Iqn + ":" + t.Tname
 
@@ -343,32 +592,187 @@ func (t *Target) ClearChapCred() error {
 	exec.Run("iscsiadm", "-m", "node", "-p", portal,
 		"-T", tname, "-o", "update",
 		"-n", "node.session.auth.username", "-v", "")
+	exec.Run("iscsiadm", "-m", "node", "-p", portal,
+		"-T", tname, "-o", "update",
+		"-n", "node.session.auth.password_in", "-v", "")
+	exec.Run("iscsiadm", "-m", "node", "-p", portal,
+		"-T", tname, "-o", "update",
+		"-n", "node.session.auth.username_in", "-v", "")
 
 	return nil
 }
 
-// FormatMountVolume tries to check fs on volume and formats if not sutable been found
-func (t *Target) FormatMountVolume(req *csi.NodePublishVolumeRequest) error {
-	var err error
+// FormatMountStaged formats device if it has no file system and mounts it on staging path
+func (t *Target) FormatMountStaged() error {
 	var msg string
 	m := mount.SafeFormatAndMount{
 		Interface: mount.New(""),
-		Exec:      mount.NewOSExec()}
+		Exec:      t.executor()}
+
+	if exists, _ := mount.PathExists(t.STPath); exists == false {
+		if err := os.MkdirAll(t.STPath, 0750); err != nil {
+			msg = fmt.Sprintf("Unable to create directory %s, Error:%s", t.STPath, err.Error())
+			return status.Error(codes.Internal, msg)
+		}
+	}
+
+	notMnt, err := m.IsLikelyNotMountPoint(t.STPath)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to check mount point %s, Error:%s", t.STPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	if notMnt == false {
+		t.l.Tracef("Device %s is already mounted to %s", t.DPath, t.STPath)
+		return nil
+	}
+
+	fsType := t.FsType
+	if len(fsType) == 0 {
+		fsType = defaultFsType
+	}
+
+	format, err := m.GetDiskFormat(t.DPath)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to identify file system on %s, Err: %s", t.DPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	if len(format) == 0 {
+		// Read only volume can not be formatted
+		if t.ReadOnly {
+			msg = fmt.Sprintf("Volume %s has no file system and can not be formatted in read only mode", t.Tname)
+			return status.Error(codes.FailedPrecondition, msg)
+		}
+
+		mkfsOpt, ok := mkfsOptions[fsType]
+		if !ok {
+			msg = fmt.Sprintf("File system %s is not supported", fsType)
+			return status.Error(codes.InvalidArgument, msg)
+		}
+		args := append(append([]string{}, mkfsOpt...), t.DPath)
+
+		t.l.Debugf("Formatting %s with %s", t.DPath, fsType)
+		if out, err := m.Exec.Run("mkfs."+fsType, args...); err != nil {
+			msg = fmt.Sprintf("Unable to format device %s with %s, Err: %s, Out: %s",
+				t.DPath, fsType, err.Error(), string(out))
+			return status.Error(codes.Internal, msg)
+		}
+	} else if format != fsType {
+		t.l.Warnf("Device %s already has %s file system, requested %s", t.DPath, format, fsType)
+		fsType = format
+	}
 
-	if exists, err := mount.PathExists(t.TPath); exists == false {
-		if err = os.MkdirAll(t.TPath, 0640); err != nil {
+	mOpt := append([]string{}, t.MountFlags...)
+	if t.ReadOnly {
+		mOpt = append(mOpt, "ro")
+	}
+
+	if err = m.Mount(t.DPath, t.STPath, fsType, mOpt); err != nil {
+		msg = fmt.Sprintf("Unable to mount device %s to %s, Err: %s",
+			t.DPath, t.STPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	return nil
+}
+
+// UnMountStaged unmounts file system from staging path
+func (t *Target) UnMountStaged() error {
+	m := mount.New("")
+
+	notMnt, err := m.IsLikelyNotMountPoint(t.STPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		msg := fmt.Sprintf("Unable to check mount point %s, Error:%s", t.STPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	if notMnt {
+		return nil
+	}
+
+	if err = m.Unmount(t.STPath); err != nil {
+		msg := fmt.Sprintf("Unable to unmount staging path %s, Err: %s", t.STPath, err.Error())
+		t.l.Warn(msg)
+		return status.Error(codes.Internal, msg)
+	}
+	return nil
+}
+
+// MountVolume bind mounts file system staged on the node to target path
+func (t *Target) MountVolume(ro bool) error {
+	var msg string
+	m := mount.New("")
+
+	if exists, _ := mount.PathExists(t.TPath); exists == false {
+		if err := os.MkdirAll(t.TPath, 0750); err != nil {
 			msg = fmt.Sprintf("Unable to create directory %s, Error:%s", t.TPath, err.Error())
 			return status.Error(codes.Internal, msg)
 
 		}
 	}
 
-	fsType := req.GetVolumeCapability().GetMount().GetFsType()
-	mOpt := req.GetVolumeCapability().GetMount().GetMountFlags()
+	notMnt, err := m.IsLikelyNotMountPoint(t.TPath)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to check mount point %s, Error:%s", t.TPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	if notMnt == false {
+		t.l.Tracef("Volume %s is already mounted to %s", t.Tname, t.TPath)
+		return nil
+	}
+
+	mOpt := []string{"bind"}
+	if ro || t.ReadOnly {
+		mOpt = append(mOpt, "ro")
+	}
+
+	if err = m.Mount(t.STPath, t.TPath, "", mOpt); err != nil {
+		msg = fmt.Sprintf("Unable to mount %s to %s, Err: %s",
+			t.STPath, t.TPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	return nil
+}
+
+// MountBlockDevice exposes attached device as a file at target path
+func (t *Target) MountBlockDevice(ro bool) error {
+	var msg string
+	m := mount.New("")
+
+	dir := filepath.Dir(t.TPath)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		msg = fmt.Sprintf("Unable to create directory %s, Error:%s", dir, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+
+	f, err := os.OpenFile(t.TPath, os.O_CREATE, 0640)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to create file %s, Error:%s", t.TPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	f.Close()
+
+	notMnt, err := m.IsLikelyNotMountPoint(t.TPath)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to check mount point %s, Error:%s", t.TPath, err.Error())
+		return status.Error(codes.Internal, msg)
+	}
+	if notMnt == false {
+		t.l.Tracef("Device %s is already mounted to %s", t.DPath, t.TPath)
+		return nil
+	}
+
+	mOpt := []string{"bind"}
+	if ro {
+		mOpt = append(mOpt, "ro")
+	}
 
-	if err = m.FormatAndMount(t.DPath, t.TPath, fsType, mOpt); err != nil {
-		msg = fmt.Sprintf("Unable to mount device %s, Err: %s",
-			t.TPath, err.Error())
+	if err = m.Mount(t.DPath, t.TPath, "", mOpt); err != nil {
+		msg = fmt.Sprintf("Unable to bind device %s to %s, Err: %s",
+			t.DPath, t.TPath, err.Error())
 		return status.Error(codes.Internal, msg)
 	}
 
@@ -421,26 +825,61 @@ func (t *Target) UnMountVolume() error {
 // GetStageStatus check if specified dir exists
 func GetStageStatus(stp string) bool {
 	//TODO: check for presence of the device
-	stp = stp + "/starget"
-	if exists, _ := mount.PathExists(stp); exists == true {
-		return true
+	for _, tp := range []string{stargetPath(stp), legacyStargetPath(stp)} {
+		if exists, _ := mount.PathExists(tp); exists == true {
+			return true
+		}
 	}
 
 	return false
 }
 
-// StageVolume discovers iscsi target and attach it
+// StageVolume attaches volume to the node using target transport
 func (t *Target) StageVolume() error {
+	return t.transport().Attach(t)
+}
+
+// UnStageVolume detaches volume from the node using target transport
+func (t *Target) UnStageVolume() error {
+	return t.transport().Detach(t)
+}
+
+// stageISCSI discovers iscsi target and attach it
+func (t *Target) stageISCSI() error {
 
 	// Scan for targets
 
 	tname := t.Iqn + ":" + t.Tname
 
 	fullPortal := t.Portal + ":" + t.PortalPort
-	exec := mount.NewOSExec()
+	exec := t.executor()
 
 	devicePath := strings.Join([]string{deviceIPPath, fullPortal, "iscsi", tname, "lun", t.Lun}, "-")
 
+	// Repeated stage of already logged in target
+	session, err := t.GetSession()
+	if err != nil {
+		return err
+	}
+	if session != nil {
+		if exist := waitForPathToExist(&devicePath, 1, t.TProtocol); exist {
+			t.l.Debugf("Target %s is already attached", tname)
+			return nil
+		}
+
+		// Session is present but device is not, rescan it
+		out, err := exec.Run("iscsiadm", "-m", "session", "-r", session.ID, "--rescan")
+		if err != nil {
+			msg := fmt.Sprintf("Unable to rescan session %s, Err: %s, Out: %s", session.ID, err.Error(), string(out))
+			return errors.New(msg)
+		}
+		if exist := waitForPathToExist(&devicePath, 10, t.TProtocol); !exist {
+			msg := fmt.Sprintf("Device %s is not present for active session %s", devicePath, session.ID)
+			return errors.New(msg)
+		}
+		return nil
+	}
+
 	out, err := exec.Run("iscsiadm", "-m", "node", "-T", tname, "-p", t.Portal, "-o", "new")
 	if err != nil {
 		msg := fmt.Sprintf("Unable to add targetation %s error: %s", tname, err.Error())
@@ -475,13 +914,13 @@ func (t *Target) StageVolume() error {
 	return nil
 }
 
-// UnStageVolume detachs iscsi target from host
-func (t *Target) UnStageVolume() error {
+// unstageISCSI detachs iscsi target from host
+func (t *Target) unstageISCSI() error {
 
 	// Scan for targets
 
 	var msg string
-	exec := mount.NewOSExec()
+	exec := t.executor()
 
 	tname := t.Iqn + ":" + t.Tname
 
@@ -498,8 +937,26 @@ func (t *Target) UnStageVolume() error {
 		return errors.New(msg)
 	}
 
-	exec.Run("iscsiadm", "-m", "node", "-p", portal, "-T", tname, "--logout")
-	exec.Run("iscsiadm", "-m", "node", "-p", portal, "-T", tname, "-o", "delete")
+	out, err := exec.Run("iscsiadm", "-m", "node", "-p", portal, "-T", tname, "--logout")
+	if err != nil && !isNoObjsFound(err, out) {
+		msg = fmt.Sprintf("Unable to logout from target %s, Err: %s, Out: %s", tname, err.Error(), string(out))
+		return errors.New(msg)
+	}
+
+	out, err = exec.Run("iscsiadm", "-m", "node", "-p", portal, "-T", tname, "-o", "delete")
+	if err != nil && !isNoObjsFound(err, out) {
+		t.l.Warnf("Unable to delete node record of target %s, Err: %s, Out: %s", tname, err.Error(), string(out))
+	}
+
+	// Make sure that session is really closed
+	session, err := t.GetSession()
+	if err != nil {
+		return err
+	}
+	if session != nil {
+		msg = fmt.Sprintf("Session %s for target %s is still active after logout", session.ID, tname)
+		return errors.New(msg)
+	}
 
 	return nil
 }
@@ -0,0 +1,145 @@
+package joviandss
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+// targetRecordV1 is Target record as written before NVMe fields were added,
+// with checksum kept inside of the record
+type targetRecordV1 struct {
+	Version    int
+	Checksum   string
+	STPath     string
+	TPath      string
+	DPath      string
+	Portal     string
+	PortalPort string
+	Iqn        string
+	Lun        string
+	Tname      string
+	CoUser     string
+	CoPass     string
+	CiUser     string
+	CiPass     string
+	TProtocol  string
+	FsType     string
+	MountFlags []string
+	ReadOnly   bool
+}
+
+func writeRecordV1(t *testing.T, path string) {
+	r := targetRecordV1{
+		Version:    1,
+		STPath:     "/stage/vol",
+		Portal:     "192.168.0.3",
+		PortalPort: "3260",
+		Iqn:        "iqn.csi.2019-04",
+		Lun:        "0",
+		Tname:      "vol",
+		TProtocol:  "tcp",
+		FsType:     "ext4",
+		MountFlags: []string{},
+	}
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Checksum = recordChecksum(data)
+	if data, err = yaml.Marshal(r); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTarget() *Target {
+	return &Target{l: logrus.NewEntry(logrus.New())}
+}
+
+func TestDeSerializeTargetV1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vol.starget")
+	writeRecordV1(t, path)
+
+	tg := testTarget()
+	if err = tg.DeSerializeTarget(path); err != nil {
+		t.Fatalf("v1 record is not accepted: %s", err)
+	}
+	if tg.Tname != "vol" || tg.Transport != "" {
+		t.Fatalf("unexpected record %+v", tg)
+	}
+}
+
+func TestDeSerializeTargetCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tg := testTarget()
+	tg.STPath = filepath.Join(dir, "vol")
+	tg.Tname = "vol"
+	tg.Lun = "0"
+	if err = tg.SerializeTarget(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := stargetPath(tg.STPath)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("lun: \"0\""), []byte("lun: \"1\""), 1)
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = testTarget().DeSerializeTarget(path)
+	if status.Code(err) != codes.DataLoss {
+		t.Fatalf("expected DataLoss, got %v", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("corrupted record is not moved aside")
+	}
+}
+
+func TestSerializeTargetRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tg := testTarget()
+	tg.STPath = filepath.Join(dir, "vol")
+	tg.Tname = "vol"
+	tg.Transport = TransportNVMeTCP
+	tg.Nqn = "nqn.2019-04.csi.joviandss:vol"
+	if err = tg.SerializeTarget(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := testTarget()
+	if err = out.DeSerializeTarget(stargetPath(tg.STPath)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Nqn != tg.Nqn || out.Version != targetRecordVersion {
+		t.Fatalf("unexpected record %+v", out)
+	}
+}
@@ -0,0 +1,203 @@
+package joviandss
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	// TransportISCSI volume is exported as iSCSI target
+	TransportISCSI = "iscsi"
+	// TransportNVMeTCP volume is exported as NVMe over TCP namespace
+	TransportNVMeTCP = "nvme-tcp"
+
+	defaultNVMePort  = 4420
+	defaultSysRoot   = "/sys"
+	nvmeSubsystemDir = "class/nvme-subsystem"
+)
+
+// nvmeNamespaceDev matches namespace block devices, hidden multipath paths are skipped
+var nvmeNamespaceDev = regexp.MustCompile(`^nvme[0-9]+n[0-9]+$`)
+
+// Transport attaches volumes exported by storage to the node
+type Transport interface {
+	Name() string
+	Attach(t *Target) error
+	Detach(t *Target) error
+	Attached(t *Target) (bool, error)
+}
+
+// isTransportSupported checks transport name provided in storage class
+func isTransportSupported(name string) bool {
+	switch name {
+	case "", TransportISCSI, TransportNVMeTCP:
+		return true
+	}
+	return false
+}
+
+// transport returns Transport used by target
+func (t *Target) transport() Transport {
+	if t.Transport == TransportNVMeTCP {
+		sysRoot := t.sysRoot
+		if len(sysRoot) == 0 {
+			sysRoot = defaultSysRoot
+		}
+		return &nvmeTransport{sysPath: filepath.Join(sysRoot, nvmeSubsystemDir)}
+	}
+	return &iscsiTransport{}
+}
+
+// executor returns tool executor of the target
+func (t *Target) executor() mount.Exec {
+	if t.exec == nil {
+		return mount.NewOSExec()
+	}
+	return t.exec
+}
+
+// SetExecutor replaces executor used to run system tools
+func (t *Target) SetExecutor(e mount.Exec) {
+	t.exec = e
+}
+
+// IsAttached checks if volume is attached to the node
+func (t *Target) IsAttached() (bool, error) {
+	return t.transport().Attached(t)
+}
+
+// iscsiTransport attaches volumes with iscsiadm
+type iscsiTransport struct{}
+
+func (it *iscsiTransport) Name() string {
+	return TransportISCSI
+}
+
+func (it *iscsiTransport) Attach(t *Target) error {
+	return t.stageISCSI()
+}
+
+func (it *iscsiTransport) Detach(t *Target) error {
+	return t.unstageISCSI()
+}
+
+func (it *iscsiTransport) Attached(t *Target) (bool, error) {
+	session, err := t.GetSession()
+	if err != nil {
+		return false, err
+	}
+	return session != nil, nil
+}
+
+// nvmeTransport attaches volumes with nvme-cli over TCP
+type nvmeTransport struct {
+	sysPath string // location of nvme subsystems in sysfs
+}
+
+func (nt *nvmeTransport) Name() string {
+	return TransportNVMeTCP
+}
+
+// findDevice looks for block device of the target namespace
+func (nt *nvmeTransport) findDevice(t *Target) (string, error) {
+	nqnFiles, err := filepath.Glob(filepath.Join(nt.sysPath, "*", "subsysnqn"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range nqnFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil || strings.TrimSpace(string(data)) != t.Nqn {
+			continue
+		}
+		dir := filepath.Dir(f)
+
+		// Namespaces are listed in subsystem with native multipath
+		// and in controller directory otherwise
+		candidates, _ := filepath.Glob(filepath.Join(dir, "nvme*n"+t.Nsid))
+		ctrl, _ := filepath.Glob(filepath.Join(dir, "nvme*", "nvme*n"+t.Nsid))
+		for _, c := range append(candidates, ctrl...) {
+			name := filepath.Base(c)
+			if nvmeNamespaceDev.MatchString(name) {
+				return "/dev/" + name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (nt *nvmeTransport) Attach(t *Target) error {
+	dev, err := nt.findDevice(t)
+	if err != nil {
+		return err
+	}
+	if len(dev) > 0 {
+		t.l.Debugf("Subsystem %s is already connected", t.Nqn)
+		t.DPath = dev
+		return nil
+	}
+
+	args := []string{"connect", "-t", "tcp", "-a", t.Portal, "-s", t.PortalPort, "-n", t.Nqn}
+	if len(t.HostNqn) > 0 {
+		args = append(args, "--hostnqn", t.HostNqn)
+	}
+
+	exec := t.executor()
+	if out, err := exec.Run("nvme", args...); err != nil {
+		msg := fmt.Sprintf("Unable to connect to subsystem %s, Err: %s, Out: %s", t.Nqn, err.Error(), string(out))
+		return errors.New(msg)
+	}
+
+	for i := 0; i < 10; i++ {
+		if dev, err = nt.findDevice(t); err == nil && len(dev) > 0 {
+			t.DPath = dev
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+
+	exec.Run("nvme", "disconnect", "-n", t.Nqn)
+	msg := fmt.Sprintf("Namespace %s of subsystem %s did not appear: Timeout after 10s", t.Nsid, t.Nqn)
+	return errors.New(msg)
+}
+
+func (nt *nvmeTransport) Detach(t *Target) error {
+	attached, err := nt.Attached(t)
+	if err != nil {
+		return err
+	}
+	if !attached {
+		return nil
+	}
+
+	out, err := t.executor().Run("nvme", "disconnect", "-n", t.Nqn)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to disconnect from subsystem %s, Err: %s, Out: %s", t.Nqn, err.Error(), string(out))
+		return errors.New(msg)
+	}
+
+	// Make sure that subsystem is really disconnected
+	if attached, err = nt.Attached(t); err != nil {
+		return err
+	}
+	if attached {
+		msg := fmt.Sprintf("Subsystem %s is still connected after disconnect", t.Nqn)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (nt *nvmeTransport) Attached(t *Target) (bool, error) {
+	dev, err := nt.findDevice(t)
+	if err != nil {
+		return false, err
+	}
+	return len(dev) > 0, nil
+}
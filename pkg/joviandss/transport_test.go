@@ -0,0 +1,182 @@
+package joviandss
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testNqn = "nqn.2019-04.csi.joviandss:vol"
+
+// nvmeHost emulates nvme-cli of a host, connect makes subsystem
+// with namespace appear in sysfs and disconnect removes it
+type nvmeHost struct {
+	*FakeExecutor
+	sysRoot string
+}
+
+func (h *nvmeHost) subsystemPath() string {
+	return filepath.Join(h.sysRoot, nvmeSubsystemDir, "nvme-subsys0")
+}
+
+func (h *nvmeHost) Run(cmd string, args ...string) ([]byte, error) {
+	out, err := h.FakeExecutor.Run(cmd, args...)
+	if err != nil {
+		return out, err
+	}
+	line := strings.Join(append([]string{cmd}, args...), " ")
+	switch {
+	case strings.HasPrefix(line, "nvme connect"):
+		err = os.MkdirAll(filepath.Join(h.subsystemPath(), "nvme0", "nvme0n1"), 0750)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(h.subsystemPath(), "subsysnqn"), []byte(testNqn+"\n"), 0640)
+		}
+	case strings.HasPrefix(line, "nvme disconnect"):
+		err = os.RemoveAll(h.subsystemPath())
+	}
+	return out, err
+}
+
+func newNVMeTestTarget(t *testing.T) (*Target, *nvmeHost, func()) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, nvmeSubsystemDir), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &nvmeHost{FakeExecutor: NewFakeExecutor(), sysRoot: dir}
+	tg := &Target{
+		l:          logrus.NewEntry(logrus.New()),
+		sysRoot:    dir,
+		Portal:     "192.168.0.3",
+		PortalPort: "4420",
+		Transport:  TransportNVMeTCP,
+		Nqn:        testNqn,
+		Nsid:       "1",
+	}
+	tg.SetExecutor(h)
+	return tg, h, func() { os.RemoveAll(dir) }
+}
+
+func TestNVMeAttach(t *testing.T) {
+	tg, h, cleanup := newNVMeTestTarget(t)
+	defer cleanup()
+
+	tg.HostNqn = "nqn.2014-08.org.nvmexpress:node"
+	if err := tg.StageVolume(); err != nil {
+		t.Fatal(err)
+	}
+	if tg.DPath != "/dev/nvme0n1" {
+		t.Fatalf("unexpected device %s", tg.DPath)
+	}
+	expected := "nvme connect -t tcp -a 192.168.0.3 -s 4420 -n " + testNqn + " --hostnqn " + tg.HostNqn
+	if len(h.Commands) != 1 || h.Commands[0] != expected {
+		t.Fatalf("unexpected commands %v", h.Commands)
+	}
+
+	// Repeated attach does not connect again
+	if err := tg.StageVolume(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Commands) != 1 {
+		t.Fatalf("unexpected commands %v", h.Commands)
+	}
+}
+
+func TestNVMeAttachFailure(t *testing.T) {
+	tg, h, cleanup := newNVMeTestTarget(t)
+	defer cleanup()
+
+	h.AddResponse("nvme connect", "failed to write to nvme-fabrics device", errors.New("exit status 1"))
+	if err := tg.StageVolume(); err == nil {
+		t.Fatal("failed connect is not reported")
+	}
+	if attached, err := tg.IsAttached(); err != nil || attached {
+		t.Fatalf("target is attached after failed connect: %v", err)
+	}
+}
+
+func TestNVMeFindDevice(t *testing.T) {
+	tg, _, cleanup := newNVMeTestTarget(t)
+	defer cleanup()
+	nt := tg.transport().(*nvmeTransport)
+
+	// Namespace listed in subsystem directory with native multipath,
+	// hidden paths of controllers are skipped
+	subsys := filepath.Join(tg.sysRoot, nvmeSubsystemDir, "nvme-subsys3")
+	for _, d := range []string{"nvme3n1", "nvme3n2", filepath.Join("nvme3", "nvme3c3n1")} {
+		if err := os.MkdirAll(filepath.Join(subsys, d), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(subsys, "subsysnqn"), []byte(testNqn), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	tg.Nsid = "2"
+	if dev, err := nt.findDevice(tg); err != nil || dev != "/dev/nvme3n2" {
+		t.Fatalf("unexpected device %s: %v", dev, err)
+	}
+
+	tg.Nsid = "5"
+	if dev, err := nt.findDevice(tg); err != nil || dev != "" {
+		t.Fatalf("unexpected device %s: %v", dev, err)
+	}
+
+	tg.Nsid = "1"
+	tg.Nqn = "nqn.2019-04.csi.joviandss:other"
+	if dev, err := nt.findDevice(tg); err != nil || dev != "" {
+		t.Fatalf("device of other subsystem %s: %v", dev, err)
+	}
+}
+
+func TestNVMeDetach(t *testing.T) {
+	tg, h, cleanup := newNVMeTestTarget(t)
+	defer cleanup()
+
+	// Detach of not attached target does nothing
+	if err := tg.UnStageVolume(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Commands) != 0 {
+		t.Fatalf("unexpected commands %v", h.Commands)
+	}
+
+	if err := tg.StageVolume(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tg.UnStageVolume(); err != nil {
+		t.Fatal(err)
+	}
+	if h.Commands[len(h.Commands)-1] != "nvme disconnect -n "+testNqn {
+		t.Fatalf("unexpected commands %v", h.Commands)
+	}
+	if attached, err := tg.IsAttached(); err != nil || attached {
+		t.Fatalf("target is attached after detach: %v", err)
+	}
+}
+
+func TestNVMeDetachStillConnected(t *testing.T) {
+	tg, h, cleanup := newNVMeTestTarget(t)
+	defer cleanup()
+
+	if err := tg.StageVolume(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnect that reports success but leaves subsystem in place
+	if err := os.MkdirAll(filepath.Join(tg.sysRoot, "keep"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	h.sysRoot = filepath.Join(tg.sysRoot, "keep")
+	if err := tg.UnStageVolume(); err == nil {
+		t.Fatal("subsystem left connected is not reported")
+	}
+}
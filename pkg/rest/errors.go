@@ -30,6 +30,7 @@ const (
 	RestRPM                   = 6 // Response Processing Malfunction
 	RestStorageFailureUnknown = 7
 	RestObjectExists          = 8
+	RestWrongKey              = 9 // Encryption key is rejected by storage
 )
 
 type RestError interface {
@@ -72,6 +73,9 @@ func (err *restError) Error() (out string) {
 	case RestStorageFailureUnknown:
 		out = fmt.Sprintf("Storage failes with unknown error: %s", err.msg)
 
+	case RestWrongKey:
+		out = fmt.Sprintf("Wrong encryption key: %s", err.msg)
+
 	default:
 		out = fmt.Sprint("Unknown internal Error. %s", err.msg)
 
@@ -160,6 +160,9 @@ type Volume struct {
 	Name                 string
 	Checksum             string
 	Refreservation       string
+	Encryption           string
+	Encryptionroot       string
+	Keystatus            string
 }
 
 // GetVolumeData data
@@ -185,8 +188,11 @@ const GetVolumesRCode = 200
 
 // CreateVolume request
 type CreateVolume struct {
-	Name string `json:"name"`
-	Size string `json:"size"`
+	Name       string `json:"name"`
+	Size       string `json:"size"`
+	Encryption string `json:"encryption,omitempty"`
+	Keyformat  string `json:"keyformat,omitempty"`
+	Key        string `json:"key,omitempty"`
 }
 
 // CreateVolumeData data
@@ -486,3 +492,439 @@ type AddUserToTargetData struct {
 
 // AddUserToTargetRCode success status code
 const AddUserToTargetRCode = 201
+
+///////////////////////////////////////////////////////////////////////////////
+/// Set Target Outgoing User
+
+// OutgoingUser target credentials used for mutual CHAP
+type OutgoingUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// SetTargetOutgoingUser request
+type SetTargetOutgoingUser struct {
+	OutgoingUser OutgoingUser `json:"outgoing_user"`
+}
+
+// SetTargetOutgoingUserRCode success status code
+const SetTargetOutgoingUserRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Target Users
+
+// TargetUser incoming user of a target
+type TargetUser struct {
+	Name string `json:"name"`
+}
+
+// GetTargetUsersData data
+type GetTargetUsersData struct {
+	Data  []TargetUser
+	Error ErrorT
+}
+
+// GetTargetUsersRCode success status code
+const GetTargetUsersRCode = 200
+
+// DeleteUserFromTargetRCode success status code
+const DeleteUserFromTargetRCode = 204
+
+///////////////////////////////////////////////////////////////////////////////
+/// Target Luns
+
+// TargetLun volume attached to a target
+type TargetLun struct {
+	Name string `json:"name"`
+	Lun  int    `json:"lun"`
+	Mode string `json:"mode"`
+}
+
+// GetTargetLunsData data
+type GetTargetLunsData struct {
+	Data  []TargetLun
+	Error ErrorT
+}
+
+// GetTargetLunsRCode success status code
+const GetTargetLunsRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Target list and sessions
+
+// GetTargetsData data
+type GetTargetsData struct {
+	Data  []Target
+	Error ErrorT
+}
+
+// GetTargetsRCode success status code
+const GetTargetsRCode = 200
+
+// TargetSession iSCSI session established with a target
+type TargetSession struct {
+	Initiator string `json:"initiator"`
+	Ip        string `json:"ip"`
+	Port      int    `json:"port"`
+}
+
+// GetTargetSessionsData data
+type GetTargetSessionsData struct {
+	Data  []TargetSession
+	Error ErrorT
+}
+
+// GetTargetSessionsRCode success status code
+const GetTargetSessionsRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// NVMe over Fabrics Subsystems
+
+// NVMeSubsystem NVMe-oF subsystem exporting volumes
+type NVMeSubsystem struct {
+	Name         string `json:"name"`
+	Active       bool   `json:"active"`
+	AllowAnyHost bool   `json:"allow_any_host"`
+}
+
+// GetNVMeSubsystemData data
+type GetNVMeSubsystemData struct {
+	Data  NVMeSubsystem
+	Error ErrorT
+}
+
+// GetNVMeSubsystemRCode success status code
+const GetNVMeSubsystemRCode = 200
+
+// CreateNVMeSubsystem request
+type CreateNVMeSubsystem struct {
+	Name         string `json:"name"`
+	Active       bool   `json:"active"`
+	AllowAnyHost bool   `json:"allow_any_host"`
+}
+
+// CreateNVMeSubsystemRCode success status code
+const CreateNVMeSubsystemRCode = 201
+
+// DeleteNVMeSubsystemRCode success status code
+const DeleteNVMeSubsystemRCode = 204
+
+///////////////////////////////////////////////////////////////////////////////
+/// NVMe over Fabrics Namespaces
+
+// NVMeNamespace volume exported by subsystem
+type NVMeNamespace struct {
+	Name string `json:"name"`
+	Nsid int    `json:"nsid"`
+	Mode string `json:"mode"`
+}
+
+// GetNVMeNamespacesData data
+type GetNVMeNamespacesData struct {
+	Data  []NVMeNamespace
+	Error ErrorT
+}
+
+// GetNVMeNamespacesRCode success status code
+const GetNVMeNamespacesRCode = 200
+
+// AddNVMeNamespaceRCode success status code
+const AddNVMeNamespaceRCode = 201
+
+// DeleteNVMeNamespaceRCode success status code
+const DeleteNVMeNamespaceRCode = 204
+
+///////////////////////////////////////////////////////////////////////////////
+/// NVMe over Fabrics Hosts
+
+// NVMeHost host allowed to connect to subsystem
+type NVMeHost struct {
+	Nqn string `json:"nqn"`
+}
+
+// GetNVMeHostsData data
+type GetNVMeHostsData struct {
+	Data  []NVMeHost
+	Error ErrorT
+}
+
+// GetNVMeHostsRCode success status code
+const GetNVMeHostsRCode = 200
+
+// AddNVMeHostRCode success status code
+const AddNVMeHostRCode = 201
+
+// DeleteNVMeHostRCode success status code
+const DeleteNVMeHostRCode = 204
+
+///////////////////////////////////////////////////////////////////////////////
+/// Datasets
+
+// Dataset file system dataset of the pool
+type Dataset struct {
+	Name       string `json:"name"`
+	Quota      int64  `json:"quota"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+// GetDatasetData data
+type GetDatasetData struct {
+	Data  Dataset
+	Error ErrorT
+}
+
+// GetDatasetRCode success status code
+const GetDatasetRCode = 200
+
+// CreateDataset request
+type CreateDataset struct {
+	Name  string `json:"name"`
+	Quota int64  `json:"quota"`
+}
+
+// CreateDatasetRCode success status code
+const CreateDatasetRCode = 201
+
+// DeleteDatasetRCode success status code
+const DeleteDatasetRCode = 204
+
+///////////////////////////////////////////////////////////////////////////////
+/// NFS Shares
+
+// NFSShareOptions NFS protocol options of the share
+type NFSShareOptions struct {
+	Enabled       bool     `json:"enabled"`
+	AllowAccessIP []string `json:"allow_access_ip"`
+	AllowWriteIP  []string `json:"allow_write_ip"`
+}
+
+// NFSShare share of the dataset
+type NFSShare struct {
+	Name string          `json:"name"`
+	Path string          `json:"path"`
+	NFS  NFSShareOptions `json:"nfs"`
+}
+
+// GetNFSShareData data
+type GetNFSShareData struct {
+	Data  NFSShare
+	Error ErrorT
+}
+
+// GetNFSShareRCode success status code
+const GetNFSShareRCode = 200
+
+// CreateNFSShareRCode success status code
+const CreateNFSShareRCode = 201
+
+// DeleteNFSShareRCode success status code
+const DeleteNFSShareRCode = 204
+
+// SetNFSShareAccess request
+type SetNFSShareAccess struct {
+	NFS NFSShareOptions `json:"nfs"`
+}
+
+// SetNFSShareAccessRCode success status code
+const SetNFSShareAccessRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Create Multiple Snapshots
+
+// VolumeSnapshot snapshot of particular volume
+type VolumeSnapshot struct {
+	Volume        string `json:"volume"`
+	Snapshot_name string `json:"snapshot_name"`
+}
+
+// CreateSnapshots request, all snapshots are made in single transaction
+type CreateSnapshots struct {
+	Snapshots []VolumeSnapshot `json:"snapshots"`
+}
+
+// CreateSnapshotsRCode success status code
+const CreateSnapshotsRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// User Properties
+
+// GetUserPropertiesData data, ZFS user properties of volume or snapshot
+type GetUserPropertiesData struct {
+	Data  map[string]string
+	Error ErrorT
+}
+
+// GetUserPropertiesRCode success status code
+const GetUserPropertiesRCode = 200
+
+// SetUserPropertiesRCode success status code
+const SetUserPropertiesRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Volume copy
+
+// Volume copy states
+const (
+	VolumeCopyRunning = "running"
+	VolumeCopyDone    = "done"
+	VolumeCopyFailed  = "failed"
+)
+
+// CopyVolume request, full copy of snapshot into a new volume
+type CopyVolume struct {
+	Name string `json:"name"`
+}
+
+// CopyVolumeRCode success status code, copy is started
+const CopyVolumeRCode = 202
+
+// VolumeCopy state of copy making new volume
+type VolumeCopy struct {
+	Source   string `json:"source"`
+	State    string `json:"state"`
+	Progress int    `json:"progress"` // percent
+	Message  string `json:"message"`
+}
+
+// GetVolumeCopyData data
+type GetVolumeCopyData struct {
+	Data  VolumeCopy
+	Error ErrorT
+}
+
+// GetVolumeCopyRCode success status code
+const GetVolumeCopyRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Rename Volume
+
+// RenameVolume request
+type RenameVolume struct {
+	Name string `json:"name"`
+}
+
+// RenameVolumeRCode success status code
+const RenameVolumeRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Rollback Volume
+
+// RollbackVolume request, volume is reverted to the snapshot
+type RollbackVolume struct {
+	ForceUmount bool `json:"force_umount"`
+}
+
+// RollbackVolumeRCode success status code
+const RollbackVolumeRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Replication
+
+// Replication task states
+const (
+	ReplicationTaskRunning = "running"
+	ReplicationTaskDone    = "done"
+	ReplicationTaskFailed  = "failed"
+)
+
+// ReplicationDestination remote volume replication stream is received into
+type ReplicationDestination struct {
+	Addr   string `json:"address"`
+	Port   int    `json:"port"`
+	User   string `json:"username"`
+	Pass   string `json:"password"`
+	Pool   string `json:"pool"`
+	Volume string `json:"volume"`
+}
+
+// ReplicationTaskDescriptor request to send snapshot to destination,
+// stream is incremental if base snapshot is set
+type ReplicationTaskDescriptor struct {
+	Name         string                 `json:"name"`
+	Snapshot     string                 `json:"snapshot"`
+	BaseSnapshot string                 `json:"base_snapshot,omitempty"`
+	Force        bool                   `json:"force"` // roll back destination changes
+	Destination  ReplicationDestination `json:"destination"`
+}
+
+// CreateReplicationTaskRCode success status code, task is started
+const CreateReplicationTaskRCode = 201
+
+// ReplicationTask state of sending snapshot
+type ReplicationTask struct {
+	Name     string `json:"name"`
+	Snapshot string `json:"snapshot"`
+	State    string `json:"state"`
+	Progress int    `json:"progress"` // percent
+	Message  string `json:"message"`
+}
+
+// GetReplicationTaskData data
+type GetReplicationTaskData struct {
+	Data  ReplicationTask
+	Error ErrorT
+}
+
+// GetReplicationTaskRCode success status code
+const GetReplicationTaskRCode = 200
+
+// DeleteReplicationTaskRCode success status code
+const DeleteReplicationTaskRCode = 204
+
+// PromoteReplicaRCode success status code
+const PromoteReplicaRCode = 200
+
+///////////////////////////////////////////////////////////////////////////////
+/// Snapshot streams
+
+// SendSnapshotRCode success status code, body is snapshot stream
+const SendSnapshotRCode = 200
+
+// ReceiveVolumeRCode success status code, volume is created from stream
+const ReceiveVolumeRCode = 201
+
+///////////////////////////////////////////////////////////////////////////////
+/// Volume encryption
+
+// VolumeEncryptionOff encryption property of not encrypted volume
+const VolumeEncryptionOff = "off"
+
+// VolumeEncryptionAES cipher of encrypted volumes
+const VolumeEncryptionAES = "aes-256-gcm"
+
+// VolumeKeyAvailable key status of volume which key is loaded
+const VolumeKeyAvailable = "available"
+
+// LoadVolumeKey request data
+type LoadVolumeKey struct {
+	Key string `json:"key"`
+}
+
+// LoadVolumeKeyRCode success status code
+const LoadVolumeKeyRCode = 200
+
+// LoadVolumeKeyECodeWrongKey error code of incorrect key
+const LoadVolumeKeyECodeWrongKey = 22
+
+///////////////////////////////////////////////////////////////////////////////
+/// Volume QoS
+
+// VolumeQoS limits of volume IO, zero means no limit
+type VolumeQoS struct {
+	ReadIOPS       int64 `json:"read_iops"`
+	WriteIOPS      int64 `json:"write_iops"`
+	ReadBandwidth  int64 `json:"read_bps"`
+	WriteBandwidth int64 `json:"write_bps"`
+}
+
+// GetVolumeQoSData response mask
+type GetVolumeQoSData struct {
+	Data  VolumeQoS
+	Error ErrorT
+}
+
+// GetVolumeQoSRCode success status code
+const GetVolumeQoSRCode = 200
+
+// SetVolumeQoSRCode success status code
+const SetVolumeQoSRCode = 200
@@ -41,6 +41,7 @@ type RestProxy struct {
 	port          int
 	authToken     string
 	httpRestProxy *http.Client
+	streamClient  *http.Client // client without timeout for data streams
 	l             *logrus.Entry
 	prot          string
 	user          string
@@ -55,6 +56,7 @@ type RestProxy struct {
 // RestProxyInterface - request client interface
 type RestProxyInterface interface {
 	Send(method, path string, data interface{}, ok int) (int, []byte, error)
+	Stream(method, path string, data io.Reader, ok int) (int, io.ReadCloser, error)
 }
 
 func (rp *RestProxy) Send(method, path string, data interface{}, ok int) (int, []byte, error) {
@@ -115,6 +117,36 @@ func (rp *RestProxy) Send(method, path string, data interface{}, ok int) (int, [
 	return res.StatusCode, bodyBytes, err
 }
 
+// Stream sends raw data and provides raw response body,
+// response is not limited by idle timeout, so it may take long.
+// Caller closes body of response.
+func (rp *RestProxy) Stream(method, path string, data io.Reader, ok int) (int, io.ReadCloser, error) {
+	rp.mu.Lock()
+	rp.requestID++
+	rp.mu.Unlock()
+
+	addr := fmt.Sprintf("%s://%s:%d/%s", rp.prot, rp.addr, rp.port, path)
+
+	rp.l.Debug(fmt.Sprintf("Stream %s request to %s", method, addr))
+
+	req, err := http.NewRequest(method, addr, data)
+	if err != nil {
+		rp.l.Warnf("Unable to create req: %s", err)
+		return 0, nil, err
+	}
+	req.SetBasicAuth(rp.user, rp.pass)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("User-Agent", "Kubernetes CSI Plugin")
+
+	res, err := rp.streamClient.Do(req)
+	if err != nil {
+		rp.l.Debugf("Request failed with error: %+v", err)
+		return 0, nil, err
+	}
+
+	return res.StatusCode, res.Body, nil
+}
+
 type RestProxyCfg struct {
 	Addr        string
 	Port        int
@@ -161,6 +193,7 @@ func NewRestProxy(cfg RestProxyCfg, l *logrus.Entry) (ri RestProxyInterface, err
 		addr:          cfg.Addr,
 		port:          cfg.Port,
 		httpRestProxy: httpRestProxy,
+		streamClient:  &http.Client{Transport: tr},
 		l:             l,
 		requestID:     0,
 		prot:          cfg.Prot,
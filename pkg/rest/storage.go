@@ -18,6 +18,8 @@ under the License.
 package rest
 
 import (
+	"io"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,23 +43,73 @@ type StorageInterface interface {
 	ListVolumes() ([]string, RestError)
 
 	CreateSnapshot(vname string, sname string) RestError
+	CreateSnapshots(snapshots []VolumeSnapshot) RestError
 	GetSnapshot(vname string, sname string) (*Snapshot, RestError)
 	DeleteSnapshot(vname string, sname string) RestError
 	ListAllSnapshots(f func(string) bool) ([]SnapshotShort, RestError)
 	ListVolumeSnapshots(string, func(string) bool) ([]SnapshotShort, RestError)
 
+	GetVolumeProperties(vname string) (map[string]string, RestError)
+	SetVolumeProperties(vname string, props map[string]string) RestError
+	GetSnapshotProperties(vname string, sname string) (map[string]string, RestError)
+	SetSnapshotProperties(vname string, sname string, props map[string]string) RestError
+
 	GetTarget(tname string) (*Target, RestError)
 	CreateTarget(tname string) RestError
 	DeleteTarget(tname string) RestError
 
 	AttachToTarget(tname string, vname string, mode string) RestError
 	DettachFromTarget(tname string, vname string) RestError
+	GetTargetLuns(tname string) ([]TargetLun, RestError)
+	ListTargets() ([]Target, RestError)
+	GetTargetSessions(tname string) ([]TargetSession, RestError)
 
 	AddUserToTarget(tname string, name string, pass string) RestError
+	DeleteUserFromTarget(tname string, name string) RestError
+	GetTargetUsers(tname string) ([]TargetUser, RestError)
+	SetTargetOutgoingUser(tname string, name string, pass string) RestError
+
+	GetNVMeSubsystem(sname string) (*NVMeSubsystem, RestError)
+	CreateNVMeSubsystem(sname string) RestError
+	DeleteNVMeSubsystem(sname string) RestError
+
+	AddNVMeNamespace(sname string, vname string, nsid int, mode string) RestError
+	DeleteNVMeNamespace(sname string, nsid int) RestError
+	GetNVMeNamespaces(sname string) ([]NVMeNamespace, RestError)
+
+	AddNVMeHost(sname string, hnqn string) RestError
+	DeleteNVMeHost(sname string, hnqn string) RestError
+	GetNVMeHosts(sname string) ([]NVMeHost, RestError)
+
+	CreateDataset(dname string, quota int64) RestError
+	GetDataset(dname string) (*Dataset, RestError)
+	DeleteDataset(dname string) RestError
+
+	CreateNFSShare(name string, dname string) RestError
+	GetNFSShare(name string) (*NFSShare, RestError)
+	DeleteNFSShare(name string) RestError
+	SetNFSShareAccess(name string, rwIPs []string, roIPs []string) RestError
 
 	CreateClone(vname string, sname string, cname string) RestError
 	DeleteClone(vname string, sname string, cname string, rChildren bool, rDependent bool) RestError
 	PromoteClone(vname string, sname string, cname string) RestError
+
+	CopyVolume(vname string, sname string, dname string) RestError
+	GetVolumeCopy(dname string) (*VolumeCopy, RestError)
+	RenameVolume(vname string, newName string) RestError
+	RollbackVolume(vname string, sname string) RestError
+
+	CreateReplicationTask(vname string, desc ReplicationTaskDescriptor) RestError
+	GetReplicationTask(vname string, tname string) (*ReplicationTask, RestError)
+	DeleteReplicationTask(vname string, tname string) RestError
+	PromoteReplica(vname string) RestError
+
+	LoadVolumeKey(vname string, key string) RestError
+	GetVolumeQoS(vname string) (*VolumeQoS, RestError)
+	SetVolumeQoS(vname string, qos VolumeQoS) RestError
+
+	SendSnapshot(vname string, sname string) (io.ReadCloser, RestError)
+	ReceiveVolume(vname string, stream io.Reader) RestError
 }
 
 type Storage struct {
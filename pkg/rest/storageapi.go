@@ -20,6 +20,8 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"time"
@@ -32,6 +34,7 @@ import (
 type CreateVolumeDescriptor struct {
 	Name string
 	Size int64
+	Key  string // hex encoded key, volume is encrypted if set
 }
 
 type SnapshotDescriptor struct {
@@ -203,6 +206,11 @@ func (s *Storage) CreateVolume(vdesc CreateVolumeDescriptor) RestError {
 	data := CreateVolume{
 		Name: vdesc.Name,
 		Size: fmt.Sprintf("%d", vdesc.Size)}
+	if len(vdesc.Key) > 0 {
+		data.Encryption = VolumeEncryptionAES
+		data.Keyformat = "hex"
+		data.Key = vdesc.Key
+	}
 
 	addr := fmt.Sprintf("api/v3/pools/%s/volumes", s.pool)
 
@@ -467,6 +475,66 @@ func (s *Storage) CreateSnapshot(vname string, sname string) RestError {
 
 }
 
+// CreateSnapshots creates snapshots of several volumes atomically
+func (s *Storage) CreateSnapshots(snapshots []VolumeSnapshot) RestError {
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateSnapshots",
+	})
+
+	data := CreateSnapshots{
+		Snapshots: snapshots}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/snapshots", s.pool)
+
+	l.Tracef("Creating %d snapshots", len(snapshots))
+	stat, body, err := s.rp.Send("POST", addr, data, CreateSnapshotsRCode)
+
+	if err != nil {
+		return GetError(RestRequestMalfunction, addr)
+	}
+
+	// Request is OK, exiting
+	if stat == CreateSnapshotsRCode {
+		return nil
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+	case 1:
+		msg := fmt.Sprintf("One of volumes doesn't exist: %s", (*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case CreateSnapshotECodeExists:
+		msg := fmt.Sprintf("One of snapshots already exists: %s", (*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
 func (s *Storage) DeleteSnapshot(vname string, sname string) RestError {
 	var err error
 	l := s.l.WithFields(logrus.Fields{
@@ -710,26 +778,18 @@ func (s *Storage) CreateClone(vname string, sname string, cname string) RestErro
 	return nil
 }
 
-func (s *Storage) DeleteClone(
-	vname string,
-	sname string,
-	cname string,
-	rChildren bool,
-	rDependent bool) RestError {
-
+// CopyVolume starts full copy of volume snapshot into a new independent volume
+func (s *Storage) CopyVolume(vname string, sname string, dname string) RestError {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "Delete Clone of the Volume",
+		"func": "CopyVolume",
 	})
 
-	data := DeleteClone{
-		RecursivelyChildren:   rChildren,
-		RecursivelyDependents: rDependent,
-		ForceUmount:           false,
+	data := CopyVolume{
+		Name: dname,
 	}
-	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/clones/%s", s.pool, vname, sname, cname)
-	msg := fmt.Sprintf("Deleting clone of snapshot %s  volume: %s", sname, vname)
-	l.Trace(msg)
-	stat, body, err := s.rp.Send("DELETE", addr, data, CreateCloneRCode)
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/copy", s.pool, vname, sname)
+	l.Tracef("Copy snapshot %s of volume %s to %s", sname, vname, dname)
+	stat, body, err := s.rp.Send("POST", addr, data, CopyVolumeRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -737,7 +797,7 @@ func (s *Storage) DeleteClone(
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	if stat == DeleteCloneRCode {
+	if stat == CopyVolumeRCode {
 		return nil
 	}
 
@@ -745,87 +805,36 @@ func (s *Storage) DeleteClone(
 
 	if er != nil {
 		msg := fmt.Sprintf("Unable to extract err message %+v", er)
-		s.l.Warn(msg)
+		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
 	switch (*errData).Errno {
-
 	case 1:
-		msg := fmt.Sprintf("Clone %s doesn't exist", cname)
-		s.l.Warn(msg)
+		msg := fmt.Sprintf("Snapshot %s doesn't exist", sname)
 		return GetError(RestResourceDNE, msg)
-	case 1000:
-		msg := fmt.Sprintf("Clone %s may have snapshots", cname)
-		s.l.Warn(msg)
-		return GetError(RestResourceBusy, msg)
-	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
-
+	case 100:
+		msg := fmt.Sprintf("Target volume %s already exists", dname)
+		return GetError(RestObjectExists, msg)
 	}
 
-	return nil
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
 }
 
-func (s *Storage) PromoteClone(vname string, sname string, cname string) RestError {
-
+// GetVolumeCopy provides state of copy making volume
+func (s *Storage) GetVolumeCopy(dname string) (*VolumeCopy, RestError) {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "PromoteClone",
+		"func": "GetVolumeCopy",
 	})
 
-	// Promote
-	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/clones/%s/promote", s.pool, vname, sname, cname)
-	msg := fmt.Sprintf("Promoting clone %s", cname)
-	l.Trace(msg)
-	stat, body, err := s.rp.Send("POST", addr, nil, CreateTargetRCode)
-
-	if err != nil {
-		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
-		l.Warn(msg)
-		return GetError(RestRequestMalfunction, msg)
-	}
-
-	if stat == PromoteCloneRCode {
-		return nil
-	}
-
-	errData, er := s.getError(body)
-
-	if er != nil {
-		msg := fmt.Sprintf("Unable to extract err message %+v", er)
-		s.l.Warn(msg)
-		return GetError(RestRequestMalfunction, msg)
-	}
-
-	switch (*errData).Errno {
-	case 1:
-		msg := fmt.Sprintf("Clone %s doesn't exist", cname)
-		s.l.Warn(msg)
-		return GetError(RestResourceDNE, msg)
-
-	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
-
-	}
-
-	return nil
-}
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/copy", s.pool, dname)
 
-func (s *Storage) GetTarget(tname string) (*Target, RestError) {
-	l := s.l.WithFields(logrus.Fields{
-		"func": "GetTarget",
-	})
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s", s.pool, tname)
-	l.Tracef(fmt.Sprintf("Getting target %s information", tname))
-	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetRCode)
+	l.Tracef("Get copy state of %s", dname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetVolumeCopyRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -834,15 +843,14 @@ func (s *Storage) GetTarget(tname string) (*Target, RestError) {
 	}
 
 	switch stat {
-	case GetTargetRCode:
-	case GetTargetRCodeDoNotExists:
+	case GetVolumeCopyRCode:
+	case 404:
 		return nil, GetError(RestResourceDNE, addr)
 	default:
 		return nil, GetError(RestStorageFailureUnknown, addr)
-
 	}
 
-	var rsp = &GetTargetData{}
+	var rsp = &GetVolumeCopyData{}
 	if errC := json.Unmarshal(body, &rsp); errC != nil {
 		msg := fmt.Sprintf(
 			"%s Data: %s, Err: %+v.",
@@ -855,24 +863,19 @@ func (s *Storage) GetTarget(tname string) (*Target, RestError) {
 	return &rsp.Data, nil
 }
 
-func (s *Storage) CreateTarget(tname string) RestError {
-
-	tname = strings.ToLower(tname)
-
+// RenameVolume changes name of volume
+func (s *Storage) RenameVolume(vname string, newName string) RestError {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "CreateTarget",
+		"func": "RenameVolume",
 	})
 
-	data := CreateTarget{
-		Name:                tname,
-		Active:              true,
-		IncomingUsersActive: true,
+	data := RenameVolume{
+		Name: newName,
 	}
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s", s.pool, vname)
 
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets", s.pool)
-
-	l.Trace(fmt.Sprintf("Creating targets for volume: %s", tname))
-	stat, body, err := s.rp.Send("POST", addr, data, CreateTargetRCode)
+	l.Tracef("Rename volume %s to %s", vname, newName)
+	stat, body, err := s.rp.Send("PUT", addr, data, RenameVolumeRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -880,12 +883,13 @@ func (s *Storage) CreateTarget(tname string) RestError {
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	// Request is OK, exiting
-	if stat == CreateTargetRCode {
+	switch stat {
+	case RenameVolumeRCode:
 		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
 	}
 
-	// Extract error information
 	if body == nil {
 		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
 		l.Warn(msg)
@@ -896,33 +900,38 @@ func (s *Storage) CreateTarget(tname string) RestError {
 
 	if er != nil {
 		msg := fmt.Sprintf("Unable to extract err message %+v", er)
-		s.l.Warn(msg)
+		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	switch (*errData).Errno {
-
-	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
-
+	if (*errData).Errno == 100 {
+		msg := fmt.Sprintf("Volume %s already exists", newName)
+		return GetError(RestObjectExists, msg)
 	}
 
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
 }
 
-func (s *Storage) DeleteTarget(tname string) RestError {
-	tname = strings.ToLower(tname)
+// RollbackVolume reverts volume to snapshot sname
+//
+// Storage refuses rollback if volume has newer snapshots
+func (s *Storage) RollbackVolume(vname string, sname string) RestError {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "CreateTarget",
+		"func": "RollbackVolume",
 	})
 
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s", s.pool, tname)
+	data := RollbackVolume{
+		ForceUmount: true,
+	}
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/rollback",
+		s.pool, vname, sname)
 
-	l.Trace(fmt.Sprintf("Deleating targets for volume: %s", tname))
-	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteTargetRCode)
+	l.Tracef("Rollback volume %s to snapshot %s", vname, sname)
+	stat, body, err := s.rp.Send("POST", addr, data, RollbackVolumeRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -930,19 +939,13 @@ func (s *Storage) DeleteTarget(tname string) RestError {
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	// Request is OK, exiting
-	if stat == DeleteTargetRCode {
+	switch stat {
+	case RollbackVolumeRCode:
 		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
 	}
 
-	// Request is OK, exiting
-	if stat == 404 {
-		msg := fmt.Sprintf("Target do not exists %s", tname)
-		s.l.Warn(msg)
-		return GetError(RestResourceDNE, msg)
-	}
-
-	// Extract error information
 	if body == nil {
 		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
 		l.Warn(msg)
@@ -953,42 +956,38 @@ func (s *Storage) DeleteTarget(tname string) RestError {
 
 	if er != nil {
 		msg := fmt.Sprintf("Unable to extract err message %+v", er)
-		s.l.Warn(msg)
+		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	switch (*errData).Errno {
-
-	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
+	if (*errData).Errno == 1 {
+		msg := fmt.Sprintf("Snapshot %s of volume %s doesn't exist", sname, vname)
+		return GetError(RestResourceDNE, msg)
+	}
 
+	if (*errData).Errno == 1000 {
+		msg := fmt.Sprintf("Volume %s is busy: %s", vname, (*errData).Message)
+		l.Warn(msg)
+		return GetError(RestResourceBusy, msg)
 	}
 
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
 }
 
-func (s *Storage) AttachToTarget(tname string,
-	vname string,
-	mode string) RestError {
-	tname = strings.ToLower(tname)
-
+// CreateReplicationTask starts sending snapshot of volume to remote storage
+func (s *Storage) CreateReplicationTask(vname string, desc ReplicationTaskDescriptor) RestError {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "AttachToTarget",
+		"func": "CreateReplicationTask",
 	})
 
-	data := AttachToTarget{
-		Name: vname,
-		Lun:  0,
-		Mode: "wt",
-	}
-
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/luns", s.pool, tname)
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/replication-tasks", s.pool, vname)
 
-	l.Tracef(fmt.Sprintf("Attaching volume to target: %s", tname))
-	stat, body, err := s.rp.Send("POST", addr, data, AttachToTargetRCode)
+	l.Tracef("Replicate snapshot %s of volume %s to %s", desc.Snapshot, vname, desc.Destination.Addr)
+	stat, body, err := s.rp.Send("POST", addr, desc, CreateReplicationTaskRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -996,52 +995,82 @@ func (s *Storage) AttachToTarget(tname string,
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	// Request is OK, exiting
-	if stat == AttachToTargetRCode {
+	if stat == CreateReplicationTaskRCode {
 		return nil
 	}
 
-	// Extract error information
-	if body == nil {
-		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
-		l.Warn(msg)
-		return GetError(RestFailureUnknown, msg)
-	}
-
 	errData, er := s.getError(body)
 
 	if er != nil {
-		msg := fmt.Sprintf("Unable to extract err message %s", er)
-		s.l.Warn(msg)
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
 	switch (*errData).Errno {
-
-	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
-
+	case 1:
+		msg := fmt.Sprintf("Snapshot %s of volume %s doesn't exist", desc.Snapshot, vname)
+		return GetError(RestResourceDNE, msg)
+	case 100:
+		msg := fmt.Sprintf("Replication task %s already exists", desc.Name)
+		return GetError(RestObjectExists, msg)
 	}
 
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
 }
 
-func (s *Storage) DettachFromTarget(tname string, vname string) RestError {
-
-	tname = strings.ToLower(tname)
-
+// GetReplicationTask provides state of replication task
+func (s *Storage) GetReplicationTask(vname string, tname string) (*ReplicationTask, RestError) {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "DettachFromTarget",
+		"func": "GetReplicationTask",
 	})
 
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/luns/%s", s.pool, tname, vname)
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/replication-tasks/%s", s.pool, vname, tname)
 
-	msg := fmt.Sprintf("Detach volume from target: %s", tname)
-	l.Tracef(msg)
-	stat, body, err := s.rp.Send("DELETE", addr, nil, AttachToTargetRCode)
+	l.Tracef("Get replication task %s of %s", tname, vname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetReplicationTaskRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetReplicationTaskRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetReplicationTaskData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return &rsp.Data, nil
+}
+
+// DeleteReplicationTask removes finished replication task or stops running one
+func (s *Storage) DeleteReplicationTask(vname string, tname string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteReplicationTask",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/replication-tasks/%s", s.pool, vname, tname)
+
+	l.Tracef("Delete replication task %s of %s", tname, vname)
+	stat, _, err := s.rp.Send("DELETE", addr, nil, DeleteReplicationTaskRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
@@ -1049,19 +1078,43 @@ func (s *Storage) DettachFromTarget(tname string, vname string) RestError {
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	// Request is OK, exiting
-	if stat == DettachFromTargetRCode {
+	switch stat {
+	case DeleteReplicationTaskRCode:
 		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
 	}
 
-	// Request is OK, exiting
-	if stat == 404 {
-		msg := fmt.Sprintf("Target do not exists %s", vname)
-		s.l.Warn(msg)
-		return GetError(RestResourceDNE, msg)
+	msg := fmt.Sprintf("Unable to delete replication task %s, code %d", tname, stat)
+	l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// PromoteReplica makes volume received by replication writable,
+// volume stops accepting replication streams
+func (s *Storage) PromoteReplica(vname string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "PromoteReplica",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/promote", s.pool, vname)
+
+	l.Tracef("Promote replica %s", vname)
+	stat, body, err := s.rp.Send("POST", addr, nil, PromoteReplicaRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case PromoteReplicaRCode:
+		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
 	}
 
-	// Extract error information
 	if body == nil {
 		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
 		l.Warn(msg)
@@ -1072,70 +1125,282 @@ func (s *Storage) DettachFromTarget(tname string, vname string) RestError {
 
 	if er != nil {
 		msg := fmt.Sprintf("Unable to extract err message %+v", er)
-		s.l.Warn(msg)
+		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
-	switch (*errData).Errno {
+	if (*errData).Errno == 1000 {
+		msg := fmt.Sprintf("Volume %s is busy: %s", vname, (*errData).Message)
+		l.Warn(msg)
+		return GetError(RestResourceBusy, msg)
+	}
 
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// LoadVolumeKey makes encrypted volume accessible
+func (s *Storage) LoadVolumeKey(vname string, key string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "LoadVolumeKey",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/load-key", s.pool, vname)
+	data := LoadVolumeKey{Key: key}
+
+	l.Tracef("Load key of volume %s", vname)
+	stat, body, err := s.rp.Send("POST", addr, data, LoadVolumeKeyRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case LoadVolumeKeyRCode:
+		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
+	}
+
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	if (*errData).Errno == LoadVolumeKeyECodeWrongKey {
+		msg := fmt.Sprintf("Key of volume %s is incorrect", vname)
+		l.Warn(msg)
+		return GetError(RestWrongKey, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetVolumeQoS provides IO limits of volume
+func (s *Storage) GetVolumeQoS(vname string) (*VolumeQoS, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetVolumeQoS",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/qos", s.pool, vname)
+
+	l.Tracef("Get QoS of volume %s", vname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetVolumeQoSRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetVolumeQoSRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
 	default:
-		msg := fmt.Sprintf("Unknown error %d, %s",
-			(*errData).Errno,
-			(*errData).Message)
-		s.l.Warn(msg)
-		return GetError(RestStorageFailureUnknown, msg)
+		return nil, GetError(RestFailureUnknown, addr)
+	}
 
+	var data GetVolumeQoSData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, GetError(RestRPM, fmt.Sprintf("Error %s for %s", err.Error(), string(body[:len(body)])))
 	}
 
+	return &data.Data, nil
 }
 
-func (s *Storage) AddUserToTarget(tname string,
-	name string,
-	pass string) RestError {
-	tname = strings.ToLower(tname)
+// SetVolumeQoS sets IO limits of volume, zero removes limit
+func (s *Storage) SetVolumeQoS(vname string, qos VolumeQoS) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "SetVolumeQoS",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/qos", s.pool, vname)
+
+	l.Tracef("Set QoS of volume %s to %+v", vname, qos)
+	stat, body, err := s.rp.Send("PUT", addr, qos, SetVolumeQoSRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case SetVolumeQoSRCode:
+		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
+	}
+
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
 
+// SendSnapshot provides full stream of snapshot data, caller closes it
+func (s *Storage) SendSnapshot(vname string, sname string) (io.ReadCloser, RestError) {
 	l := s.l.WithFields(logrus.Fields{
-		"func": "AddUserToTarget",
+		"func": "SendSnapshot",
 	})
 
-	data := AddUserToTarget{
-		Name:     name,
-		Password: pass,
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/send", s.pool, vname, sname)
+
+	l.Tracef("Send snapshot %s of %s", sname, vname)
+	stat, body, err := s.rp.Stream("GET", addr, nil, SendSnapshotRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
 	}
 
-	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/incoming-users", s.pool, tname)
+	switch stat {
+	case SendSnapshotRCode:
+		return body, nil
+	case 404:
+		body.Close()
+		return nil, GetError(RestResourceDNE, addr)
+	}
 
-	l.Tracef("Set CHAP user for tartget: %s", tname)
-	stat, body, err := s.rp.Send("POST", addr, data, AddUserToTargetRCode)
+	body.Close()
+	msg := fmt.Sprintf("Unable to send snapshot %s, code %d", sname, stat)
+	l.Warn(msg)
+	return nil, GetError(RestStorageFailureUnknown, msg)
+}
+
+// ReceiveVolume creates volume out of snapshot stream
+func (s *Storage) ReceiveVolume(vname string, stream io.Reader) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "ReceiveVolume",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/receive", s.pool, vname)
+
+	l.Tracef("Receive volume %s", vname)
+	stat, body, err := s.rp.Stream("POST", addr, stream, ReceiveVolumeRCode)
 
 	if err != nil {
 		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
 		l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
+	defer body.Close()
 
-	// Request is OK, exiting
-	if stat == AddUserToTargetRCode {
+	if stat == ReceiveVolumeRCode {
 		return nil
 	}
 
-	// Extract error information
-	if body == nil {
+	data, err := ioutil.ReadAll(body)
+	if err != nil || len(data) == 0 {
 		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
 		l.Warn(msg)
 		return GetError(RestFailureUnknown, msg)
 	}
 
+	errData, er := s.getError(data)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	if (*errData).Errno == 100 {
+		msg := fmt.Sprintf("Volume %s already exists", vname)
+		return GetError(RestObjectExists, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+func (s *Storage) DeleteClone(
+	vname string,
+	sname string,
+	cname string,
+	rChildren bool,
+	rDependent bool) RestError {
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "Delete Clone of the Volume",
+	})
+
+	data := DeleteClone{
+		RecursivelyChildren:   rChildren,
+		RecursivelyDependents: rDependent,
+		ForceUmount:           false,
+	}
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/clones/%s", s.pool, vname, sname, cname)
+	msg := fmt.Sprintf("Deleting clone of snapshot %s  volume: %s", sname, vname)
+	l.Trace(msg)
+	stat, body, err := s.rp.Send("DELETE", addr, data, CreateCloneRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	if stat == DeleteCloneRCode {
+		return nil
+	}
+
 	errData, er := s.getError(body)
 
 	if er != nil {
-		msg := fmt.Sprintf("Unable to extract err message %s", er)
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
 		s.l.Warn(msg)
 		return GetError(RestRequestMalfunction, msg)
 	}
 
 	switch (*errData).Errno {
 
+	case 1:
+		msg := fmt.Sprintf("Clone %s doesn't exist", cname)
+		s.l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 1000:
+		msg := fmt.Sprintf("Clone %s may have snapshots", cname)
+		s.l.Warn(msg)
+		return GetError(RestResourceBusy, msg)
 	default:
 		msg := fmt.Sprintf("Unknown error %d, %s",
 			(*errData).Errno,
@@ -1145,6 +1410,1668 @@ func (s *Storage) AddUserToTarget(tname string,
 
 	}
 
+	return nil
+}
+
+func (s *Storage) PromoteClone(vname string, sname string, cname string) RestError {
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "PromoteClone",
+	})
+
+	// Promote
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/clones/%s/promote", s.pool, vname, sname, cname)
+	msg := fmt.Sprintf("Promoting clone %s", cname)
+	l.Trace(msg)
+	stat, body, err := s.rp.Send("POST", addr, nil, CreateTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	if stat == PromoteCloneRCode {
+		return nil
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+	case 1:
+		msg := fmt.Sprintf("Clone %s doesn't exist", cname)
+		s.l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+	return nil
+}
+
+func (s *Storage) GetTarget(tname string) (*Target, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetTarget",
+	})
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s", s.pool, tname)
+	l.Tracef(fmt.Sprintf("Getting target %s information", tname))
+	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetTargetRCode:
+	case GetTargetRCodeDoNotExists:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+
+	}
+
+	var rsp = &GetTargetData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return &rsp.Data, nil
+}
+
+func (s *Storage) CreateTarget(tname string) RestError {
+
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateTarget",
+	})
+
+	data := CreateTarget{
+		Name:                tname,
+		Active:              true,
+		IncomingUsersActive: true,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets", s.pool)
+
+	l.Trace(fmt.Sprintf("Creating targets for volume: %s", tname))
+	stat, body, err := s.rp.Send("POST", addr, data, CreateTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == CreateTargetRCode {
+		return nil
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+func (s *Storage) DeleteTarget(tname string) RestError {
+	tname = strings.ToLower(tname)
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateTarget",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s", s.pool, tname)
+
+	l.Trace(fmt.Sprintf("Deleating targets for volume: %s", tname))
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == DeleteTargetRCode {
+		return nil
+	}
+
+	// Request is OK, exiting
+	if stat == 404 {
+		msg := fmt.Sprintf("Target do not exists %s", tname)
+		s.l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+func (s *Storage) AttachToTarget(tname string,
+	vname string,
+	mode string) RestError {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "AttachToTarget",
+	})
+
+	// ro - read only, wt - write through
+	data := AttachToTarget{
+		Name: vname,
+		Lun:  0,
+		Mode: mode,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/luns", s.pool, tname)
+
+	l.Tracef(fmt.Sprintf("Attaching volume to target: %s", tname))
+	stat, body, err := s.rp.Send("POST", addr, data, AttachToTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == AttachToTargetRCode {
+		return nil
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %s", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+func (s *Storage) DettachFromTarget(tname string, vname string) RestError {
+
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DettachFromTarget",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/luns/%s", s.pool, tname, vname)
+
+	msg := fmt.Sprintf("Detach volume from target: %s", tname)
+	l.Tracef(msg)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, AttachToTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == DettachFromTargetRCode {
+		return nil
+	}
+
+	// Request is OK, exiting
+	if stat == 404 {
+		msg := fmt.Sprintf("Target do not exists %s", vname)
+		s.l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+func (s *Storage) AddUserToTarget(tname string,
+	name string,
+	pass string) RestError {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "AddUserToTarget",
+	})
+
+	data := AddUserToTarget{
+		Name:     name,
+		Password: pass,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/incoming-users", s.pool, tname)
+
+	l.Tracef("Set CHAP user for tartget: %s", tname)
+	stat, body, err := s.rp.Send("POST", addr, data, AddUserToTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == AddUserToTargetRCode {
+		return nil
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %s", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+// GetTargetLuns lists volumes attached to target
+func (s *Storage) GetTargetLuns(tname string) ([]TargetLun, RestError) {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetTargetLuns",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/luns", s.pool, tname)
+
+	l.Tracef("Get luns of target: %s", tname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetLunsRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetTargetLunsRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetTargetLunsData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// GetTargetUsers lists incoming users of target
+func (s *Storage) GetTargetUsers(tname string) ([]TargetUser, RestError) {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetTargetUsers",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/incoming-users", s.pool, tname)
+
+	l.Tracef("Get CHAP users of target: %s", tname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetUsersRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetTargetUsersRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetTargetUsersData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// ListTargets lists iSCSI targets of the pool
+func (s *Storage) ListTargets() ([]Target, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "ListTargets",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets", s.pool)
+
+	l.Trace("Get list of targets")
+	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetsRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	if stat != GetTargetsRCode {
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetTargetsData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// GetTargetSessions lists initiators connected to target
+func (s *Storage) GetTargetSessions(tname string) ([]TargetSession, RestError) {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetTargetSessions",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/sessions", s.pool, tname)
+
+	l.Tracef("Get sessions of target: %s", tname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetTargetSessionsRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetTargetSessionsRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetTargetSessionsData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// DeleteUserFromTarget removes incoming user from target
+func (s *Storage) DeleteUserFromTarget(tname string, name string) RestError {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteUserFromTarget",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s/incoming-users/%s", s.pool, tname, name)
+
+	l.Tracef("Delete CHAP user from target: %s", tname)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteUserFromTargetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == DeleteUserFromTargetRCode {
+		return nil
+	}
+
+	if stat == 404 {
+		msg := fmt.Sprintf("User or target do not exists %s", tname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %s", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+}
+
+// SetTargetOutgoingUser sets credentials target uses to authenticate itself
+// to initiators during mutual CHAP
+func (s *Storage) SetTargetOutgoingUser(tname string,
+	name string,
+	pass string) RestError {
+	tname = strings.ToLower(tname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "SetTargetOutgoingUser",
+	})
+
+	data := SetTargetOutgoingUser{
+		OutgoingUser: OutgoingUser{
+			Name:     name,
+			Password: pass,
+		},
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/san/iscsi/targets/%s", s.pool, tname)
+
+	l.Tracef("Set outgoing CHAP user for target: %s", tname)
+	stat, body, err := s.rp.Send("PUT", addr, data, SetTargetOutgoingUserRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	// Request is OK, exiting
+	if stat == SetTargetOutgoingUserRCode {
+		return nil
+	}
+
+	if stat == 404 {
+		msg := fmt.Sprintf("Target do not exists %s", tname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %s", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch (*errData).Errno {
+
+	default:
+		msg := fmt.Sprintf("Unknown error %d, %s",
+			(*errData).Errno,
+			(*errData).Message)
+		s.l.Warn(msg)
+		return GetError(RestStorageFailureUnknown, msg)
+
+	}
+
+}
+
+// GetNVMeSubsystem provides information about NVMe-oF subsystem
+func (s *Storage) GetNVMeSubsystem(sname string) (*NVMeSubsystem, RestError) {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetNVMeSubsystem",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s", s.pool, sname)
+
+	l.Tracef("Get subsystem: %s", sname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetNVMeSubsystemRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetNVMeSubsystemRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetNVMeSubsystemData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return &rsp.Data, nil
+}
+
+// CreateNVMeSubsystem creates NVMe-oF subsystem
+//
+// Access is limited to hosts added with AddNVMeHost
+func (s *Storage) CreateNVMeSubsystem(sname string) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateNVMeSubsystem",
+	})
+
+	data := CreateNVMeSubsystem{
+		Name:         sname,
+		Active:       true,
+		AllowAnyHost: false,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems", s.pool)
+
+	l.Tracef("Creating subsystem: %s", sname)
+	stat, body, err := s.rp.Send("POST", addr, data, CreateNVMeSubsystemRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case CreateNVMeSubsystemRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Pool do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Subsystem already exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// DeleteNVMeSubsystem deletes NVMe-oF subsystem
+func (s *Storage) DeleteNVMeSubsystem(sname string) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteNVMeSubsystem",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s", s.pool, sname)
+
+	l.Tracef("Deleting subsystem: %s", sname)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteNVMeSubsystemRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case DeleteNVMeSubsystemRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Subsystem do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Subsystem is in use %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// AddNVMeNamespace exports volume as namespace of subsystem
+//
+// ro - read only, wt - write through
+func (s *Storage) AddNVMeNamespace(sname string, vname string, nsid int, mode string) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "AddNVMeNamespace",
+	})
+
+	data := NVMeNamespace{
+		Name: vname,
+		Nsid: nsid,
+		Mode: mode,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/namespaces", s.pool, sname)
+
+	l.Tracef("Adding namespace to subsystem: %s", sname)
+	stat, body, err := s.rp.Send("POST", addr, data, AddNVMeNamespaceRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case AddNVMeNamespaceRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Subsystem or volume do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Namespace already exists in subsystem %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// DeleteNVMeNamespace removes namespace from subsystem
+func (s *Storage) DeleteNVMeNamespace(sname string, nsid int) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteNVMeNamespace",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/namespaces/%d", s.pool, sname, nsid)
+
+	l.Tracef("Deleting namespace from subsystem: %s", sname)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteNVMeNamespaceRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case DeleteNVMeNamespaceRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Namespace or subsystem do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Namespace is in use %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetNVMeNamespaces lists namespaces of subsystem
+func (s *Storage) GetNVMeNamespaces(sname string) ([]NVMeNamespace, RestError) {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetNVMeNamespaces",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/namespaces", s.pool, sname)
+
+	l.Tracef("Get namespaces of subsystem: %s", sname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetNVMeNamespacesRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetNVMeNamespacesRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetNVMeNamespacesData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// AddNVMeHost allows host to connect to subsystem
+func (s *Storage) AddNVMeHost(sname string, hnqn string) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "AddNVMeHost",
+	})
+
+	data := NVMeHost{
+		Nqn: hnqn,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/hosts", s.pool, sname)
+
+	l.Tracef("Adding host to subsystem: %s", sname)
+	stat, body, err := s.rp.Send("POST", addr, data, AddNVMeHostRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case AddNVMeHostRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Subsystem do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Host is already allowed for subsystem %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// DeleteNVMeHost forbids host to connect to subsystem
+func (s *Storage) DeleteNVMeHost(sname string, hnqn string) RestError {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteNVMeHost",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/hosts/%s", s.pool, sname, hnqn)
+
+	l.Tracef("Deleting host from subsystem: %s", sname)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteNVMeHostRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case DeleteNVMeHostRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Host or subsystem do not exists %s", sname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Host is in use %s", sname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetNVMeHosts lists hosts allowed to connect to subsystem
+func (s *Storage) GetNVMeHosts(sname string) ([]NVMeHost, RestError) {
+	sname = strings.ToLower(sname)
+
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetNVMeHosts",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/nvmeof/subsystems/%s/hosts", s.pool, sname)
+
+	l.Tracef("Get hosts of subsystem: %s", sname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetNVMeHostsRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetNVMeHostsRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetNVMeHostsData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return rsp.Data, nil
+}
+
+// CreateDataset creates file system dataset limited by quota
+func (s *Storage) CreateDataset(dname string, quota int64) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateDataset",
+	})
+
+	data := CreateDataset{
+		Name:  dname,
+		Quota: quota,
+	}
+
+	addr := fmt.Sprintf("api/v3/pools/%s/filesystems", s.pool)
+
+	l.Tracef("Creating dataset: %s", dname)
+	stat, body, err := s.rp.Send("POST", addr, data, CreateDatasetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case CreateDatasetRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Pool do not exists %s", dname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Dataset already exists %s", dname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetDataset provides information about dataset
+func (s *Storage) GetDataset(dname string) (*Dataset, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetDataset",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/filesystems/%s", s.pool, dname)
+
+	l.Tracef("Get dataset: %s", dname)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetDatasetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetDatasetRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetDatasetData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return &rsp.Data, nil
+}
+
+// DeleteDataset deletes dataset
+func (s *Storage) DeleteDataset(dname string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteDataset",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/filesystems/%s", s.pool, dname)
+
+	l.Tracef("Deleting dataset: %s", dname)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteDatasetRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case DeleteDatasetRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Dataset do not exists %s", dname)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Dataset is in use %s", dname)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// CreateNFSShare shares dataset over NFS
+//
+// Share is not accessible until access is granted with SetNFSShareAccess
+func (s *Storage) CreateNFSShare(name string, dname string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "CreateNFSShare",
+	})
+
+	data := NFSShare{
+		Name: name,
+		Path: fmt.Sprintf("%s/%s", s.pool, dname),
+		NFS: NFSShareOptions{
+			Enabled:       true,
+			AllowAccessIP: []string{},
+			AllowWriteIP:  []string{},
+		},
+	}
+
+	addr := fmt.Sprintf("api/v3/shares")
+
+	l.Tracef("Creating NFS share: %s", name)
+	stat, body, err := s.rp.Send("POST", addr, data, CreateNFSShareRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case CreateNFSShareRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Dataset do not exists %s", name)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Share already exists %s", name)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetNFSShare provides information about NFS share
+func (s *Storage) GetNFSShare(name string) (*NFSShare, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetNFSShare",
+	})
+
+	addr := fmt.Sprintf("api/v3/shares/%s", name)
+
+	l.Tracef("Get NFS share: %s", name)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetNFSShareRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetNFSShareRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetNFSShareData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	return &rsp.Data, nil
+}
+
+// DeleteNFSShare deletes NFS share
+func (s *Storage) DeleteNFSShare(name string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "DeleteNFSShare",
+	})
+
+	addr := fmt.Sprintf("api/v3/shares/%s", name)
+
+	l.Tracef("Deleting NFS share: %s", name)
+	stat, body, err := s.rp.Send("DELETE", addr, nil, DeleteNFSShareRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case DeleteNFSShareRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Share do not exists %s", name)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Share is in use %s", name)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// SetNFSShareAccess sets addresses of hosts allowed to access share
+//
+// rwIPs are allowed to write, roIPs only to read
+func (s *Storage) SetNFSShareAccess(name string, rwIPs []string, roIPs []string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "SetNFSShareAccess",
+	})
+
+	data := SetNFSShareAccess{
+		NFS: NFSShareOptions{
+			Enabled:       true,
+			AllowAccessIP: append(append([]string{}, rwIPs...), roIPs...),
+			AllowWriteIP:  rwIPs,
+		},
+	}
+
+	addr := fmt.Sprintf("api/v3/shares/%s", name)
+
+	l.Tracef("Setting access to NFS share: %s", name)
+	stat, body, err := s.rp.Send("PUT", addr, data, SetNFSShareAccessRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case SetNFSShareAccessRCode:
+		return nil
+	case 404:
+		msg := fmt.Sprintf("Share do not exists %s", name)
+		l.Warn(msg)
+		return GetError(RestResourceDNE, msg)
+	case 409:
+		msg := fmt.Sprintf("Share access conflict %s", name)
+		l.Warn(msg)
+		return GetError(RestObjectExists, msg)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetVolumeProperties provides user properties of volume
+func (s *Storage) GetVolumeProperties(vname string) (map[string]string, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetVolumeProperties",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/user-properties", s.pool, vname)
+
+	l.Tracef("Get properties of %s", addr)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetUserPropertiesRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetUserPropertiesRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetUserPropertiesData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	if rsp.Data == nil {
+		rsp.Data = make(map[string]string)
+	}
+	return rsp.Data, nil
+}
+
+// SetVolumeProperties sets user properties of volume
+//
+// Property with empty value is removed
+func (s *Storage) SetVolumeProperties(vname string, props map[string]string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "SetVolumeProperties",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/user-properties", s.pool, vname)
+
+	l.Tracef("Set properties of %s", addr)
+	stat, body, err := s.rp.Send("PUT", addr, props, SetUserPropertiesRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case SetUserPropertiesRCode:
+		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
+}
+
+// GetSnapshotProperties provides user properties of snapshot
+func (s *Storage) GetSnapshotProperties(vname string, sname string) (map[string]string, RestError) {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "GetSnapshotProperties",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/user-properties", s.pool, vname, sname)
+
+	l.Tracef("Get properties of %s", addr)
+	stat, body, err := s.rp.Send("GET", addr, nil, GetUserPropertiesRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return nil, GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case GetUserPropertiesRCode:
+	case 404:
+		return nil, GetError(RestResourceDNE, addr)
+	default:
+		return nil, GetError(RestStorageFailureUnknown, addr)
+	}
+
+	var rsp = &GetUserPropertiesData{}
+	if errC := json.Unmarshal(body, &rsp); errC != nil {
+		msg := fmt.Sprintf(
+			"%s Data: %s, Err: %+v.",
+			addr,
+			string(body[:len(body)]),
+			errC)
+		return nil, GetError(RestRPM, msg)
+	}
+
+	if rsp.Data == nil {
+		rsp.Data = make(map[string]string)
+	}
+	return rsp.Data, nil
+}
+
+// SetSnapshotProperties sets user properties of snapshot
+//
+// Property with empty value is removed
+func (s *Storage) SetSnapshotProperties(vname string, sname string, props map[string]string) RestError {
+	l := s.l.WithFields(logrus.Fields{
+		"func": "SetSnapshotProperties",
+	})
+
+	addr := fmt.Sprintf("api/v3/pools/%s/volumes/%s/snapshots/%s/user-properties", s.pool, vname, sname)
+
+	l.Tracef("Set properties of %s", addr)
+	stat, body, err := s.rp.Send("PUT", addr, props, SetUserPropertiesRCode)
+
+	if err != nil {
+		msg := fmt.Sprintf("Internal failure in communication with storage %s.", s.addr)
+		l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	switch stat {
+	case SetUserPropertiesRCode:
+		return nil
+	case 404:
+		return GetError(RestResourceDNE, addr)
+	}
+
+	// Extract error information
+	if body == nil {
+		msg := fmt.Sprintf("Unidentifiable error, code : %d.", stat)
+		l.Warn(msg)
+		return GetError(RestFailureUnknown, msg)
+	}
+
+	errData, er := s.getError(body)
+
+	if er != nil {
+		msg := fmt.Sprintf("Unable to extract err message %+v", er)
+		s.l.Warn(msg)
+		return GetError(RestRequestMalfunction, msg)
+	}
+
+	msg := fmt.Sprintf("Unknown error %d, %s",
+		(*errData).Errno,
+		(*errData).Message)
+	s.l.Warn(msg)
+	return GetError(RestStorageFailureUnknown, msg)
 }
 
 func GetTimeStamp(tRaw string) (int64, RestError) {